@@ -0,0 +1,39 @@
+// Package admin serves debugging endpoints for production: profiling, runtime variables
+// and configuration of logging. They are mounted under prefix and protected by authentication middleware
+// with dedicated credentials (not credentials of users of service):
+//   auth := middleware.BasicAuth("admin", func(username, password string) bool {
+//       return subtle.ConstantTimeCompare([]byte(username+":"+password), []byte(os.Getenv("ADMIN_CREDENTIALS"))) == 1
+//   })
+//   router.Handler("GET", "/admin/*path", admin.Handler("/admin", auth))
+//   router.Handler("PUT", "/admin/*path", admin.Handler("/admin", auth))
+// Endpoints:
+//   {prefix}/debug/pprof/  profiles of net/http/pprof
+//   {prefix}/debug/vars    variables of expvar
+//   {prefix}/log           configuration of logging, see log.ConfigHandler
+package admin
+
+import (
+	"expvar"
+	"lib/log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Handler serves admin endpoints under prefix, requests are authenticated by auth middleware.
+// Auth is required: profiles and variables expose internals of service.
+func Handler(prefix string, auth func(http.Handler) http.HandlerFunc) http.Handler {
+	if auth == nil {
+		panic("admin: auth middleware is required")
+	}
+	mux := http.NewServeMux()
+	// Paths of pprof are fixed: pprof.Index resolves profiles by "/debug/pprof/" prefix,
+	// so mux serves them without admin prefix.
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/log", log.ConfigHandler())
+	return auth(http.StripPrefix(prefix, mux))
+}
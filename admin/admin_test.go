@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"io"
+	"lib/log"
+	"lib/middleware"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = io.Discard
+
+	h := Handler("/admin", middleware.BasicAuth("admin", func(username, password string) bool {
+		return username == "admin" && password == "secret"
+	}))
+	for _, tc := range []struct {
+		path     string
+		password string
+		code     int
+	}{
+		{"/admin/debug/pprof/", "secret", 200},
+		{"/admin/debug/pprof/goroutine", "secret", 200},
+		{"/admin/debug/vars", "secret", 200},
+		{"/admin/log", "secret", 200},
+		{"/admin/log", "wrong", 401},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.SetBasicAuth("admin", tc.password)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.path, w.Code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Pinger is implemented by *sql.DB and clients of other storages.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Ping checks connection to database.
+func Ping(p Pinger) Checker {
+	return CheckerFunc(p.PingContext)
+}
+
+// URL checks downstream service by GET request, it's healthy if it responds with 2xx.
+// Request is not logged: probes are frequent, transitions are logged anyway.
+func URL(url string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return nil
+	})
+}
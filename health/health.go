@@ -0,0 +1,162 @@
+// Package health reports health of service to orchestrator (Kubernetes probes, load balancer).
+// Applications register named checkers in init function:
+//   health.Register("db", health.Ping(db))
+//   health.Register("billing", health.URL("http://billing/healthz"))
+// and expose handlers in router:
+//   router.Handler("GET", "/healthz", health.Liveness())
+//   router.Handler("GET", "/readyz", health.Readiness())
+// Probes are frequent, exclude them from transaction log:
+//   middleware.Skip = append(middleware.Skip, middleware.SkipPaths("/healthz", "/readyz"))
+// Transitions of checks between healthy and unhealthy states are logged,
+// failures with Error field, so people on duty are notified.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lib/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker checks health of dependency, it returns nil if dependency is healthy.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts function to Checker.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Timeout limits duration of all checks of probe, checks are run concurrently.
+var Timeout = 5 * time.Second
+
+type check struct {
+	name    string
+	checker Checker
+	// Result of the last check, transitions are logged.
+	healthy bool
+}
+
+var (
+	mu        sync.Mutex
+	liveness  []*check
+	readiness []*check
+)
+
+// Register adds checker of dependency without which service can't handle requests (database, downstream service).
+// Unhealthy service is not ready: it doesn't get traffic but it isn't restarted.
+func Register(name string, c Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	readiness = append(readiness, &check{name: name, checker: c, healthy: true})
+}
+
+// RegisterLiveness adds checker of service itself (deadlock, exhausted resources).
+// Service which is not alive is restarted, so don't register checks of dependencies here.
+func RegisterLiveness(name string, c Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	liveness = append(liveness, &check{name: name, checker: c, healthy: true})
+}
+
+// Liveness responds with 200 if all liveness checks pass, otherwise with 503.
+func Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, &liveness)
+	}
+}
+
+// Readiness responds with 200 if all readiness checks pass, otherwise with 503.
+func Readiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, &readiness)
+	}
+}
+
+// Report is body of probe response.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func respond(w http.ResponseWriter, r *http.Request, checks *[]*check) {
+	mu.Lock()
+	registered := append([]*check(nil), *checks...)
+	mu.Unlock()
+
+	report := run(r.Context(), registered)
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	body, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func run(ctx context.Context, checks []*check) Report {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			errs[i] = safeCheck(ctx, c.checker)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	for i, c := range checks {
+		result := CheckResult{Status: "ok"}
+		if errs[i] != nil {
+			result = CheckResult{Status: "fail", Error: errs[i].Error()}
+			report.Status = "fail"
+		}
+		report.Checks[c.name] = result
+		transition(ctx, c, errs[i])
+	}
+	return report
+}
+
+// Panic of checker must not crash probe, it's reported as failure of check.
+func safeCheck(ctx context.Context, c Checker) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return c.Check(ctx)
+}
+
+// Only transitions are logged, otherwise every probe would flood log.
+func transition(ctx context.Context, c *check, err error) {
+	mu.Lock()
+	changed := c.healthy != (err == nil)
+	c.healthy = err == nil
+	mu.Unlock()
+	if !changed {
+		return
+	}
+	if err != nil {
+		log.Log("health check failed", log.FromContext(ctx), log.Error(err), log.Context(map[string]string{"check": c.name}))
+		return
+	}
+	log.Log("health check recovered", log.FromContext(ctx), log.Context(map[string]string{"check": c.name}))
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lib/log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	var dbErr error
+	Register("db", CheckerFunc(func(ctx context.Context) error { return dbErr }))
+	defer func() { readiness = nil }()
+
+	probe := func() (int, string) {
+		w := httptest.NewRecorder()
+		Readiness()(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := probe(); code != 200 || body != `{"status":"ok","checks":{"db":{"status":"ok"}}}` {
+		t.Errorf("unexpected response %d %s", code, body)
+	}
+	dbErr = errors.New("connection refused")
+	if code, body := probe(); code != 503 || body != `{"status":"fail","checks":{"db":{"status":"fail","error":"connection refused"}}}` {
+		t.Errorf("unexpected response %d %s", code, body)
+	}
+	probe()
+	dbErr = nil
+	probe()
+	if events := strings.Count(buf.String(), `"message"`); events != 2 {
+		t.Errorf("expected 2 transitions logged, got %d:\n%s", events, buf.String())
+	}
+}
@@ -0,0 +1,189 @@
+package httpclient
+
+import (
+	"errors"
+	"lib/log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending request to host whose circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerPolicy configures circuit breaker of each destination host: when share of failed requests
+// (network errors and 5xx responses) reaches FailureRate, circuit opens and requests to host fail fast
+// with ErrCircuitOpen instead of waiting for timeout. After OpenTimeout one trial request is sent (half-open circuit):
+// circuit closes if it succeeds, otherwise it opens again. State changes are logged as warnings.
+type BreakerPolicy struct {
+	// Failure rate from 0 to 1 which opens circuit, zero disables breaker.
+	FailureRate float64
+	// Failure rate isn't considered until window has so many requests, 10 by default.
+	MinRequests int
+	// Requests are counted in windows of this duration, 10 seconds by default.
+	Window time.Duration
+	// Circuit stays open for this duration, 5 seconds by default.
+	OpenTimeout time.Duration
+}
+
+func (p *BreakerPolicy) setDefaults() {
+	if p.MinRequests == 0 {
+		p.MinRequests = 10
+	}
+	if p.Window == 0 {
+		p.Window = 10 * time.Second
+	}
+	if p.OpenTimeout == 0 {
+		p.OpenTimeout = 5 * time.Second
+	}
+}
+
+type circuitState string
+
+const (
+	closed   circuitState = "closed"
+	open     circuitState = "open"
+	halfOpen circuitState = "half-open"
+)
+
+type breakers struct {
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	trials   ticket
+}
+
+// ticket identifies trial request of half-open circuit, other requests get zero ticket.
+type ticket uint64
+
+type circuit struct {
+	state       circuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// Ticket of trial request of half-open circuit in flight, zero if there is none.
+	trial ticket
+}
+
+func newBreakers(policy BreakerPolicy) *breakers {
+	policy.setDefaults()
+	return &breakers{policy: policy, circuits: make(map[string]*circuit)}
+}
+
+// allow reports whether request to host can be sent. Allowed request must be reported by record or release
+// with returned ticket: only result of trial request decides state of half-open circuit.
+func (b *breakers) allow(host string) (ticket, bool) {
+	if b.policy.FailureRate == 0 {
+		return 0, true
+	}
+	now := time.Now()
+	b.mu.Lock()
+	c := b.circuit(host, now)
+	switch c.state {
+	case open:
+		if now.Sub(c.openedAt) < b.policy.OpenTimeout {
+			b.mu.Unlock()
+			return 0, false
+		}
+		c.state, c.trial = halfOpen, b.nextTrial()
+		t := c.trial
+		b.mu.Unlock()
+		logStateChange(host, open, halfOpen, nil)
+		return t, true
+	case halfOpen:
+		defer b.mu.Unlock()
+		if c.trial != 0 {
+			return 0, false
+		}
+		c.trial = b.nextTrial()
+		return c.trial, true
+	}
+	b.mu.Unlock()
+	return 0, true
+}
+
+func (b *breakers) nextTrial() ticket {
+	b.trials++
+	return b.trials
+}
+
+// record counts result of request to host.
+func (b *breakers) record(host string, t ticket, failed bool) {
+	if b.policy.FailureRate == 0 {
+		return
+	}
+	now := time.Now()
+	b.mu.Lock()
+	c := b.circuit(host, now)
+	switch c.state {
+	case closed:
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		rate := float64(c.failures) / float64(c.requests)
+		if c.requests < b.policy.MinRequests || rate < b.policy.FailureRate {
+			b.mu.Unlock()
+			return
+		}
+		c.state, c.openedAt = open, now
+		b.mu.Unlock()
+		logStateChange(host, closed, open, log.Float("failure_rate", rate))
+	case halfOpen:
+		if t == 0 || t != c.trial {
+			// Request was allowed before circuit opened, it tells nothing about recovery of host.
+			b.mu.Unlock()
+			return
+		}
+		c.trial = 0
+		if failed {
+			c.state, c.openedAt = open, now
+			b.mu.Unlock()
+			logStateChange(host, halfOpen, open, nil)
+			return
+		}
+		c.state, c.windowStart, c.requests, c.failures = closed, now, 0, 0
+		b.mu.Unlock()
+		logStateChange(host, halfOpen, closed, nil)
+	default:
+		// Request was allowed before circuit opened.
+		b.mu.Unlock()
+	}
+}
+
+// release frees trial of half-open circuit if request has no result, e.g. it's canceled by caller.
+func (b *breakers) release(host string, t ticket) {
+	if b.policy.FailureRate == 0 || t == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.circuits[host]; c != nil && c.state == halfOpen && c.trial == t {
+		c.trial = 0
+	}
+}
+
+// circuit returns circuit of host, counts of closed circuit are reset each window.
+func (b *breakers) circuit(host string, now time.Time) *circuit {
+	c := b.circuits[host]
+	if c == nil {
+		c = &circuit{state: closed, windowStart: now}
+		b.circuits[host] = c
+	}
+	if c.state == closed && now.Sub(c.windowStart) > b.policy.Window {
+		c.windowStart, c.requests, c.failures = now, 0, 0
+	}
+	return c
+}
+
+func logStateChange(host string, from, to circuitState, setter log.SetFieldValue) {
+	setters := []log.SetFieldValue{
+		log.Warning(),
+		log.Context(map[string]string{"host": host, "from": string(from), "to": string(to)}),
+	}
+	if setter != nil {
+		setters = append(setters, setter)
+	}
+	log.Log("circuit breaker changed state", setters...)
+}
@@ -0,0 +1,294 @@
+package httpclient
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"lib/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is response stored in CacheStore.
+type CachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// Time response was received or revalidated.
+	Time time.Time `json:"time"`
+	// Values of request headers listed in Vary header of response.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// CacheStore stores responses of Client by keys, e.g. in memory (MemoryCacheStore) or in Redis.
+// Store must be safe for concurrent use, stored response must not be modified.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+}
+
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+type cacheState struct {
+	key    string
+	entry  *CachedResponse
+	status string
+	// Authorization header is set by caller, response may be private to caller.
+	authorized bool
+}
+
+// lookupCache finds response to request, nil state means request isn't cacheable.
+// Request with conditional headers of caller isn't cacheable: caller expects 304, not cached response.
+// If stale response can be revalidated, conditional headers are added to returned clone of request.
+func (c *Client) lookupCache(r *http.Request) (*http.Request, *cacheState) {
+	if c.cache == nil || r.Method != http.MethodGet {
+		return r, nil
+	}
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		return r, nil
+	}
+	reqControl := parseCacheControl(r.Header)
+	if _, ok := reqControl["no-store"]; ok {
+		return r, nil
+	}
+	// Token of TokenSource is set later, before request is sent.
+	authorized := r.Header.Get("Authorization") != "" || c.tokens != nil
+	s := &cacheState{key: r.URL.String(), status: "miss", authorized: authorized}
+	entry, ok := c.cache.Get(s.key)
+	if !ok || !entry.matches(r) {
+		return r, s
+	}
+	_, noCache := reqControl["no-cache"]
+	if !noCache && entry.fresh(time.Now()) {
+		s.entry, s.status = entry, "hit"
+		return r, s
+	}
+	etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return r, s
+	}
+	s.entry, s.status = entry, "stale"
+	r = r.Clone(r.Context())
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+	return r, s
+}
+
+// logged sets cache status of response in event.
+func (s *cacheState) logged(resp *http.Response) log.SetFieldValue {
+	return func(e *log.Event) {
+		if s == nil {
+			return
+		}
+		status := s.status
+		if s.revalidated(resp) {
+			status = "revalidated"
+		}
+		log.Context(map[string]string{"cache": status})(e)
+	}
+}
+
+func (s *cacheState) revalidated(resp *http.Response) bool {
+	return s.entry != nil && resp != nil && resp.StatusCode == http.StatusNotModified
+}
+
+// store stores response if it's cacheable and returns response to caller: cached one if it's revalidated.
+func (s *cacheState) store(store CacheStore, r *http.Request, resp *http.Response, body []byte) *http.Response {
+	if s == nil {
+		return resp
+	}
+	now := time.Now()
+	if s.revalidated(resp) {
+		entry := *s.entry
+		entry.Header = entry.Header.Clone()
+		for k, v := range resp.Header {
+			if k != "Content-Length" {
+				entry.Header[k] = v
+			}
+		}
+		entry.Time = now
+		store.Set(s.key, &entry)
+		return entry.response(r)
+	}
+	if !s.storable(resp) {
+		return resp
+	}
+	entry := &CachedResponse{StatusCode: resp.StatusCode, Header: resp.Header.Clone(), Body: body, Time: now}
+	for _, name := range strings.Split(resp.Header.Get("Vary"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if entry.Vary == nil {
+				entry.Vary = make(map[string]string)
+			}
+			entry.Vary[http.CanonicalHeaderKey(name)] = r.Header.Get(name)
+		}
+	}
+	store.Set(s.key, entry)
+	return resp
+}
+
+func (s *cacheState) storable(resp *http.Response) bool {
+	if !cacheableStatuses[resp.StatusCode] || strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+	control := parseCacheControl(resp.Header)
+	if _, ok := control["no-store"]; ok {
+		return false
+	}
+	if _, ok := control["private"]; ok {
+		return false
+	}
+	_, public := control["public"]
+	_, shared := control["s-maxage"]
+	if s.authorized && !public && !shared {
+		return false
+	}
+	_, ok := lifetime(resp.Header)
+	return ok || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+func (e *CachedResponse) matches(r *http.Request) bool {
+	for name, value := range e.Vary {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *CachedResponse) fresh(now time.Time) bool {
+	if _, ok := parseCacheControl(e.Header)["no-cache"]; ok {
+		return false
+	}
+	ttl, ok := lifetime(e.Header)
+	if !ok {
+		return false
+	}
+	age := now.Sub(e.Time)
+	if seconds, err := strconv.Atoi(e.Header.Get("Age")); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age < ttl
+}
+
+func (e *CachedResponse) response(r *http.Request) *http.Response {
+	return newResponse(r, e.StatusCode, e.Header.Clone(), e.Body)
+}
+
+// lifetime returns freshness lifetime of response by s-maxage, max-age or Expires.
+func lifetime(header http.Header) (time.Duration, bool) {
+	control := parseCacheControl(header)
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := control[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return 0, true
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	expires := header.Get("Expires")
+	if expires == "" {
+		return 0, false
+	}
+	t, err := http.ParseTime(expires)
+	if err != nil {
+		// Invalid date means expired response.
+		return 0, true
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	return t.Sub(date), true
+}
+
+func parseCacheControl(header http.Header) map[string]string {
+	control := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				control[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return control
+}
+
+// newResponse returns response to request with buffered body.
+func newResponse(r *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
+
+// MemoryCacheStore keeps the most recently used responses in memory.
+type MemoryCacheStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// NewMemoryCacheStore returns store of maxEntries responses, the least recently used one is evicted by new one.
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (s *MemoryCacheStore) Get(key string) (*CachedResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*memoryCacheEntry).resp, true
+}
+
+func (s *MemoryCacheStore) Set(key string, resp *CachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryCacheEntry).resp = resp
+		s.order.MoveToFront(el)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	if s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
@@ -0,0 +1,89 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"lib/log"
+	"net"
+	"syscall"
+)
+
+// Failures of transport are classified, so callers can branch on cause by errors.Is:
+//   if errors.Is(err, httpclient.ErrConnectionRefused) { ... }
+// Error field of log event is name of class (timeout, connection_refused, dns, tls),
+// text of error is saved in context as "error_text". Errors registered in log package
+// (see log.RegisterError) keep their classification.
+var (
+	ErrTimeout           = errors.New("timeout")
+	ErrConnectionRefused = errors.New("connection refused")
+	ErrDNS               = errors.New("DNS lookup failed")
+	ErrTLS               = errors.New("TLS handshake failed")
+)
+
+// TransportError is failure of request classified by Kind, one of errors above.
+type TransportError struct {
+	Kind error
+	Err  error
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransportError) Is(target error) bool {
+	return target == e.Kind
+}
+
+var errorNames = map[error]string{
+	ErrTimeout:           "timeout",
+	ErrConnectionRefused: "connection_refused",
+	ErrDNS:               "dns",
+	ErrTLS:               "tls",
+}
+
+// classify wraps error of transport into TransportError, other errors (e.g. cancellation) are returned as is.
+// DNS failure is classified as such even if lookup timed out.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var kind error
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &dnsErr):
+		kind = ErrDNS
+	case isTimeout(err):
+		kind = ErrTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		kind = ErrConnectionRefused
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		kind = ErrTLS
+	default:
+		return err
+	}
+	return &TransportError{Kind: kind, Err: err}
+}
+
+// classified sets name of class of error as Error of event, unless error is registered in log package.
+func classified(err error) log.SetFieldValue {
+	return func(e *log.Event) {
+		var transportErr *TransportError
+		if e.ErrorCode != "" || !errors.As(err, &transportErr) {
+			return
+		}
+		e.Error = errorNames[transportErr.Kind]
+		log.Context(map[string]string{"error_text": err.Error()})(e)
+	}
+}
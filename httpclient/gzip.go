@@ -0,0 +1,45 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+)
+
+// compress compresses body of request by gzip if it's large enough and request has no Content-Encoding yet.
+// It returns body to send and whether it's compressed.
+func (c *Client) compress(r *http.Request, body []byte) ([]byte, bool) {
+	if c.gzipOver <= 0 || len(body) < c.gzipOver || r.Header.Get("Content-Encoding") != "" {
+		return body, false
+	}
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write(body)
+	w.Close()
+	r.Header.Set("Content-Encoding", "gzip")
+	r.ContentLength = int64(b.Len())
+	return b.Bytes(), true
+}
+
+// decompress decompresses buffered body of gzip response. Transport decompresses response itself
+// only if it has set Accept-Encoding, not if caller has set it or compression of transport is disabled.
+// It returns size of compressed body, zero if body isn't compressed.
+func decompress(resp *http.Response, body []byte) ([]byte, int, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" || len(body) == 0 {
+		return body, 0, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, len(body), err
+	}
+	decompressed, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, len(body), err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(decompressed))
+	resp.Uncompressed = true
+	return decompressed, len(body), nil
+}
@@ -0,0 +1,52 @@
+package httpclient
+
+import (
+	"context"
+	"net"
+	"net/url"
+)
+
+// dialContext dials target of address overridden by Config.Hosts.
+func dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error), hosts map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(hosts) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if target, ok := overrideHost(hosts, addr); ok {
+			addr = target
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// overrideHost returns target of address (host:port) mapped by host and port or by host only.
+// Target without port keeps port of address.
+func overrideHost(hosts map[string]string, addr string) (string, bool) {
+	if target, ok := hosts[addr]; ok {
+		return target, true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	target, ok := hosts[host]
+	if !ok {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, port)
+	}
+	return target, true
+}
+
+// hostPort returns address of URL with default port of its scheme.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
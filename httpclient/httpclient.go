@@ -2,67 +2,624 @@ package httpclient
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/propagation"
+	"io"
 	"io/ioutil"
 	"lib/log"
+	"lib/metrics"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// Config of Client, zero values are replaced by defaults.
+type Config struct {
+	// Timeout of request when Send gets zero timeout, including reading of response body.
+	// 30 seconds by default.
+	Timeout time.Duration
+	// Idle (keep-alive) connections to all hosts, 100 by default.
+	MaxIdleConns int
+	// Idle connections per host, 10 by default: default of net/http (2) makes
+	// service calling a few hosts concurrently open and close connections all the time.
+	MaxIdleConnsPerHost int
+	// Connections per host including active ones, requests over limit wait for connection.
+	// Zero means no limit.
+	MaxConnsPerHost int
+	// Idle connection is closed after it, 90 seconds by default.
+	IdleConnTimeout time.Duration
+	// Timeouts of phases of request, they are shorter than Timeout to fail fast on dead destination.
+	// Phase which timed out is logged as timeout_phase in context of event:
+	// connection, dns, connect, tls_handshake, request, response_header, response_body.
+	// Dial (TCP connect) timeout is 10 seconds by default.
+	DialTimeout time.Duration
+	// TLS handshake timeout is 10 seconds by default.
+	TLSHandshakeTimeout time.Duration
+	// Time to wait for response headers after request is written, zero means no limit except Timeout.
+	ResponseHeaderTimeout time.Duration
+	// TLS of connections, see TLSConfig.
+	TLS TLSConfig
+	// Hosts maps destinations to targets which are dialed instead of resolved addresses, e.g. to test
+	// against staging or for split-horizon DNS:
+	//   Hosts: map[string]string{"api.example.org": "10.0.0.5", "auth.example.org:443": "10.0.0.6:8443"}
+	// Key is host or host:port, target without port keeps port of destination. URL, Host header
+	// and TLS server name stay the same. Target of request is logged in context of its events.
+	// Proxy is dialed instead of destination, it's mapped by its own address.
+	Hosts map[string]string
+	// Proxy returns proxy of request, nil URL means no proxy. Proxy is chosen by environment variables
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY by default (http.ProxyFromEnvironment).
+	// Use http.ProxyURL for fixed proxy and NoProxy to ignore environment.
+	// Requests to https destinations are tunneled by CONNECT, proxy with https URL is connected by TLS.
+	// Proxy of request is logged in context of its events, without password.
+	Proxy func(r *http.Request) (*url.URL, error)
+	// Retry policy, requests are not retried by default. Timeout applies to each attempt.
+	Retry RetryPolicy
+	// Circuit breaker policy, breaker is disabled by default.
+	Breaker BreakerPolicy
+	// Rate limit of requests, requests are not limited by default.
+	RateLimit RateLimitPolicy
+	// TokenSource authorizes requests by Bearer tokens, e.g. ClientCredentials.
+	TokenSource TokenSource
+	// Signers sign requests in order, e.g. HMACSigner or AWSSigV4.
+	Signers []Signer
+	// Request bodies of at least so many bytes are compressed by gzip, zero disables compression.
+	// Destination must accept Content-Encoding: gzip. Gzip responses are decompressed anyway,
+	// even if transport hasn't done it (caller has set Accept-Encoding). Sizes of compressed bodies
+	// are logged as request_compressed_bytes and response_compressed_bytes, logged bodies are decompressed.
+	GzipRequestsOver int
+	// StatusAsError makes Send return *StatusError for responses with status 400 and above,
+	// so callers don't check status by hand. Response is returned with error, its body can be read.
+	// It doesn't apply to Stream.
+	StatusAsError bool
+	// Cache stores responses of GET requests, e.g. NewMemoryCacheStore(1000). Client is shared cache (RFC 7234):
+	//   - fresh response (by s-maxage, max-age or Expires) is returned without request;
+	//   - stale response with ETag or Last-Modified is revalidated by If-None-Match or If-Modified-Since,
+	//     response 304 is replaced with cached one;
+	//   - responses with no-store or private directives are not stored, as well as responses to requests
+	//     with Authorization header (set by caller or TokenSource), unless they are public;
+	//   - request with no-cache directive is revalidated, request with no-store directive
+	//     or conditional headers (If-None-Match, If-Modified-Since) bypasses cache.
+	// Outbound log event has "cache" in context: hit (logged without request), miss, stale or revalidated.
+	Cache CacheStore
+	// Transport replaces transport of Client, e.g. MockTransport or Recorder in tests.
+	// Settings of connections above (pool, timeouts of phases, TLS and Proxy) don't apply to it.
+	Transport http.RoundTripper
+
+	// BeforeRequest hooks are called in order before each attempt to send request, e.g. to set auth header.
+	// The first error stops request without retries, it's returned by Send.
+	BeforeRequest []func(r *http.Request) error
+	// AfterResponse hooks are called in order after each attempt, before it's logged,
+	// e.g. to record metrics. Body of response is buffered (except for Stream), hook can read it.
+	// Response is nil if error is not nil.
+	AfterResponse []func(r *http.Request, resp *http.Response, err error)
+
+	// Redaction of logged requests and responses, rules of log package (log.RedactedQueryKeys,
+	// log.HeaderAllowlist and log.HeaderDenylist) apply too.
+	// Values of these headers are replaced with log.Redacted, DefaultRedactedHeaders by default
+	// (empty non-nil slice disables redaction).
+	RedactedHeaders []string
+	// Values of these keys of JSON bodies (at any depth) are replaced, log.RedactedBodyKeys by default.
+	RedactedBodyKeys []string
+	// Values of these query parameters are replaced, in addition to log.RedactedQueryKeys.
+	RedactedQueryKeys []string
+}
+
+// NoProxy is Proxy of Client which connects to destinations directly.
+func NoProxy(r *http.Request) (*url.URL, error) {
+	return nil, nil
+}
+
+// DefaultRedactedHeaders carry credentials, they are redacted in log events of Client by default.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Client sends requests and logs them. Create it once and reuse it:
+// connections to destinations are pooled by its transport. Client is safe for concurrent use.
+// Count and duration of attempts and count of retries are recorded in metrics by host and method
+// (see metrics.ClientRequests), so latency of dependencies is visible without parsing of log.
+type Client struct {
+	timeout   time.Duration
+	transport http.RoundTripper
+	proxy     func(r *http.Request) (*url.URL, error)
+	hosts     map[string]string
+	retry     RetryPolicy
+	breakers  *breakers
+	limiters  *rateLimiters
+	tokens    TokenSource
+	signers   []Signer
+	cache     CacheStore
+	gzipOver  int
+
+	statusAsError bool
+
+	beforeRequest []func(r *http.Request) error
+	afterResponse []func(r *http.Request, resp *http.Response, err error)
+
+	redactedHeaders   []string
+	redactedBodyKeys  []string
+	redactedQueryKeys []string
+}
+
+func New(config Config) *Client {
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = 100
+	}
+	if config.MaxIdleConnsPerHost == 0 {
+		config.MaxIdleConnsPerHost = 10
+	}
+	if config.IdleConnTimeout == 0 {
+		config.IdleConnTimeout = 90 * time.Second
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 10 * time.Second
+	}
+	if config.TLSHandshakeTimeout == 0 {
+		config.TLSHandshakeTimeout = 10 * time.Second
+	}
+	if config.RedactedHeaders == nil {
+		config.RedactedHeaders = DefaultRedactedHeaders
+	}
+	if config.RedactedBodyKeys == nil {
+		config.RedactedBodyKeys = log.RedactedBodyKeys
+	}
+	config.Retry.setDefaults()
+	if config.Proxy == nil {
+		config.Proxy = http.ProxyFromEnvironment
+	}
+	c := &Client{
+		timeout:  config.Timeout,
+		proxy:    config.Proxy,
+		hosts:    config.Hosts,
+		retry:    config.Retry,
+		breakers: newBreakers(config.Breaker),
+		limiters: newRateLimiters(config.RateLimit),
+		tokens:   config.TokenSource,
+		signers:  config.Signers,
+		cache:    config.Cache,
+		gzipOver: config.GzipRequestsOver,
+
+		statusAsError: config.StatusAsError,
+
+		beforeRequest: config.BeforeRequest,
+		afterResponse: config.AfterResponse,
+
+		redactedHeaders:   config.RedactedHeaders,
+		redactedBodyKeys:  config.RedactedBodyKeys,
+		redactedQueryKeys: config.RedactedQueryKeys,
+		transport: &http.Transport{
+			Proxy: config.Proxy,
+			DialContext: dialContext((&net.Dialer{
+				Timeout:   config.DialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext, config.Hosts),
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          config.MaxIdleConns,
+			MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       config.MaxConnsPerHost,
+			IdleConnTimeout:       config.IdleConnTimeout,
+			TLSClientConfig:       config.TLS.tlsConfig(),
+			TLSHandshakeTimeout:   config.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+	if config.Transport != nil {
+		c.transport, c.proxy, c.hosts = config.Transport, NoProxy, nil
+	}
+	return c
+}
+
+// ReferenceIDHeader carries reference id of request to destination, so its log events have the same reference id
+// (see middleware.ReferenceID). Reference id returned by destination in this header is logged as response_reference_id.
+var ReferenceIDHeader = "Reference-ID"
+
+// DefaultClient is used by Send, customize it for project in init function:
+//   httpclient.DefaultClient = httpclient.New(httpclient.Config{MaxIdleConnsPerHost: 100})
+var DefaultClient = New(Config{})
+
+// Send sends request by DefaultClient, see Client.Send.
 func Send(r *http.Request, timeout time.Duration, referenceID string) (*http.Response, error) {
-	var reqBody []byte
-	var respBody []byte
+	return DefaultClient.Send(r, timeout, referenceID)
+}
+
+// SendContext sends request by DefaultClient, see Client.SendContext.
+func SendContext(ctx context.Context, r *http.Request) (*http.Response, error) {
+	return DefaultClient.SendContext(ctx, r)
+}
+
+// Send sends request and logs it with response, body of response is read and can be read again.
+// Zero timeout means timeout of Client.
+// If referenceID is empty, reference id is taken from context of request (see middleware.ReferenceID),
+// it is sent to destination in ReferenceIDHeader.
+// Trace context of request (see middleware.TraceContext) is propagated to destination
+// in traceparent and tracestate headers. Create request with context of incoming request
+// (http.NewRequestWithContext) to continue its trace.
+// Remaining time of context with deadline (see middleware.Deadline) is passed to destination
+// in X-Request-Timeout header, unless request has it already.
+func (c *Client) Send(r *http.Request, timeout time.Duration, referenceID string) (*http.Response, error) {
+	if timeout == 0 {
+		timeout = c.timeout
+	}
+	return c.send(r, timeout, referenceID)
+}
+
+// SendContext is Send with ctx instead of context of request: request is canceled with ctx,
+// deadline of ctx replaces timeout of Client. Reference id, user and trace are taken from ctx.
+//   resp, err := client.SendContext(r.Context(), request)
+// Failed request is logged with failure "timeout" or "canceled" in context,
+// cancellation (e.g. client of incoming request has disconnected) is logged as warning.
+func (c *Client) SendContext(ctx context.Context, r *http.Request) (*http.Response, error) {
+	timeout := c.timeout
+	if _, ok := ctx.Deadline(); ok {
+		timeout = 0
+	}
+	return c.send(r.WithContext(ctx), timeout, "")
+}
+
+// Zero timeout means no timeout.
+func (c *Client) send(r *http.Request, timeout time.Duration, referenceID string) (*http.Response, error) {
+	var reqBody, sentBody []byte
+	var compressed bool
 	var err error
 
+	if referenceID == "" {
+		referenceID = log.ReferenceIDFromContext(r.Context())
+	}
+	user := log.UserFromContext(r.Context())
+
 	if r.Body != nil {
 		reqBody, err = ioutil.ReadAll(r.Body)
 		if err != nil {
 			log.Log(
 				"failed ioutil.ReadAll",
 				log.ReferenceID(referenceID),
+				log.Trace(r.Context()),
+				log.User(user),
 				log.Error(err),
 				log.Context(map[string]string{"body": fmt.Sprintf("%#v", r.Body)}),
-				log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, nil),
+				c.loggedRequest(r, nil, 0),
 			)
 			return nil, err
 		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
+		r.Body.Close()
+		sentBody, compressed = c.compress(r, reqBody)
+		// Body is sent again by retries and by transport itself, when it resends request on new connection.
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(sentBody)), nil
+		}
 	}
 
-	client := http.Client{Timeout: timeout}
-	resp, err := client.Do(r)
-	if err != nil {
+	r, cached := c.lookupCache(r)
+	if cached != nil && cached.status == "hit" {
+		start := time.Now()
+		resp := cached.entry.response(r)
 		log.Log(
-			"failed client.Do",
+			fmt.Sprintf("out '%s %s' %d", r.Method, r.Host+r.URL.Path, resp.StatusCode),
 			log.ReferenceID(referenceID),
-			log.Error(err),
-			log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqBody),
+			log.Trace(r.Context()),
+			log.User(user),
+			c.loggedRequest(r, reqBody, len(reqBody)),
+			c.loggedResponse(resp, cached.entry.Body, len(cached.entry.Body)),
+			log.Latency(time.Since(start)),
+			cached.logged(resp),
 		)
-		return nil, err
+		return c.result(resp)
 	}
 
-	if resp.Body != nil {
-		respBody, err = ioutil.ReadAll(resp.Body)
+	propagate(r, referenceID)
+
+	// http.Client is cheap, connections are pooled by shared transport.
+	client := http.Client{Transport: c.transport, Timeout: timeout}
+	var rejectedToken string
+	for attempt := 1; ; attempt++ {
+		if r.GetBody != nil {
+			r.Body, _ = r.GetBody()
+		}
+		t, err := c.admit(r, referenceID, user, reqBody, sentBody, rejectedToken)
+		if err != nil {
+			return nil, err
+		}
+		var ph phases
+		start := time.Now()
+		resp, respBody, received, err := c.do(&client, ph.trace(r))
+		latency := time.Since(start)
+		observe(r, resp, err, latency)
+		if err != nil && r.Context().Err() != nil {
+			// Caller has given up, it tells nothing about host.
+			c.breakers.release(r.URL.Host, t)
+		} else {
+			c.breakers.record(r.URL.Host, t, err != nil || resp.StatusCode >= 500)
+		}
+		delay, retry := c.retry.delay(r, attempt, resp, err)
+		notRetried := retry && !idempotent(r)
+		if notRetried {
+			retry = false
+			if err != nil {
+				err = &NotRetriedError{Method: r.Method, URL: r.URL.Redacted(), Err: err}
+			}
+		}
+
+		setters := []log.SetFieldValue{
+			log.ReferenceID(referenceID),
+			log.Trace(r.Context()),
+			log.User(user),
+			c.loggedRequest(r, reqBody, len(reqBody)),
+			log.Latency(latency),
+		}
+		if c.retry.MaxAttempts > 1 {
+			setters = append(setters, log.Int("attempt", attempt))
+		}
+		if cached != nil {
+			setters = append(setters, cached.logged(resp))
+		}
+		if compressed {
+			setters = append(setters, log.Int("request_compressed_bytes", len(sentBody)))
+		}
+		if received > 0 {
+			setters = append(setters, log.Int("response_compressed_bytes", received))
+		}
+		if retry {
+			metrics.ClientRetries.WithLabelValues(r.URL.Host, r.Method).Inc()
+			setters = append(setters, log.Duration("retry_delay_ms", delay))
+			if after, ok := retryAfter(resp, start.Add(latency)); ok {
+				setters = append(setters, log.Duration("retry_after_ms", after))
+			}
+		}
+		if notRetried {
+			setters = append(setters, log.Context(map[string]string{"not_retried": "non-idempotent method"}))
+		}
+		if err != nil && isTimeout(err) {
+			setters = append(setters, log.Context(map[string]string{"timeout_phase": ph.current()}))
+		}
+		switch {
+		case err != nil && retry:
+			// Failure is not final, people on duty must not be notified about it.
+			setters = append(setters, log.Warning(), log.Context(map[string]string{"reason": err.Error()}))
+		case err != nil:
+			setters = append(setters, failed(err))
+		}
+		switch {
+		case resp == nil:
+			log.Log("failed client.Do", setters...)
+		case err != nil:
+			setters = append(setters, c.loggedResponse(resp, nil, 0))
+			log.Log("failed ioutil.ReadAll", setters...)
+		default:
+			setters = append(setters, c.loggedResponse(resp, respBody, len(respBody)))
+			// Destination may not trust reference id of request and generate its own.
+			if id := resp.Header.Get(ReferenceIDHeader); id != "" && id != referenceID {
+				setters = append(setters, log.Context(map[string]string{"response_reference_id": id}))
+			}
+			log.Log(fmt.Sprintf("out '%s %s' %d", r.Method, r.Host+r.URL.Path, resp.StatusCode), setters...)
+		}
+
+		if c.tokens != nil && rejectedToken == "" && err == nil && resp.StatusCode == http.StatusUnauthorized {
+			// Request with new token is not an attempt of RetryPolicy.
+			rejectedToken = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			attempt--
+			continue
+		}
+		if !retry {
+			if err != nil {
+				return nil, err
+			}
+			return c.result(cached.store(c.cache, r, resp, respBody))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			if err != nil {
+				return nil, err
+			}
+			return c.result(cached.store(c.cache, r, resp, respBody))
+		}
+	}
+}
+
+// result returns error of response with error status if Client is configured so, see Config.StatusAsError.
+func (c *Client) result(resp *http.Response) (*http.Response, error) {
+	if c.statusAsError && resp.StatusCode >= 400 {
+		return resp, newStatusError(resp)
+	}
+	return resp, nil
+}
+
+// propagate passes reference id, trace context and deadline of request to destination.
+func propagate(r *http.Request, referenceID string) {
+	if referenceID != "" && r.Header.Get(ReferenceIDHeader) == "" {
+		r.Header.Set(ReferenceIDHeader, referenceID)
+	}
+	propagation.TraceContext{}.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	if deadline, ok := r.Context().Deadline(); ok && r.Header.Get("X-Request-Timeout") == "" {
+		if remaining := time.Until(deadline).Truncate(time.Millisecond); remaining > 0 {
+			r.Header.Set("X-Request-Timeout", remaining.String())
+		}
+	}
+}
+
+// admit authorizes request by token, calls BeforeRequest hooks, waits for rate limit, signs request
+// and asks circuit breaker of host whether request can be sent. Admitted request must be reported to breaker.
+// Request is signed after wait, so time in signature is fresh. Signature covers sentBody, body as it's sent
+// (e.g. compressed), reqBody is logged.
+func (c *Client) admit(r *http.Request, referenceID, user string, reqBody, sentBody []byte, rejectedToken string) (ticket, error) {
+	if c.tokens != nil {
+		token, err := c.tokens.Token(r.Context(), rejectedToken)
 		if err != nil {
 			log.Log(
-				"failed ioutil.ReadAll",
+				"failed TokenSource.Token",
 				log.ReferenceID(referenceID),
+				log.Trace(r.Context()),
+				log.User(user),
+				failed(err),
+				c.loggedRequest(r, reqBody, len(reqBody)),
+			)
+			return 0, err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for _, hook := range c.beforeRequest {
+		if err := hook(r); err != nil {
+			log.Log(
+				"failed BeforeRequest hook",
+				log.ReferenceID(referenceID),
+				log.Trace(r.Context()),
+				log.User(user),
 				log.Error(err),
-				log.Context(map[string]string{"body": fmt.Sprintf("%#v", resp.Body)}),
-				log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqBody),
-				log.Response(resp.StatusCode, resp.Header, nil),
+				c.loggedRequest(r, reqBody, len(reqBody)),
 			)
-			return nil, err
+			return 0, err
+		}
+	}
+	waited, err := c.limiters.wait(r.Context(), r.URL.Host)
+	if err != nil {
+		log.Log(
+			"failed client.Do",
+			log.ReferenceID(referenceID),
+			log.Trace(r.Context()),
+			log.User(user),
+			failed(err),
+			log.Duration("rate_limit_wait_ms", waited),
+			c.loggedRequest(r, reqBody, len(reqBody)),
+		)
+		return 0, err
+	}
+	if waited >= c.limiters.policy.LogWait {
+		log.Log(
+			"waited for rate limit",
+			log.ReferenceID(referenceID),
+			log.Trace(r.Context()),
+			log.User(user),
+			log.Duration("rate_limit_wait_ms", waited),
+			c.loggedRequest(r, reqBody, len(reqBody)),
+		)
+	}
+	for _, signer := range c.signers {
+		if err := signer.Sign(r, sentBody); err != nil {
+			log.Log(
+				"failed Signer.Sign",
+				log.ReferenceID(referenceID),
+				log.Trace(r.Context()),
+				log.User(user),
+				log.Error(err),
+				c.loggedRequest(r, reqBody, len(reqBody)),
+			)
+			return 0, err
+		}
+	}
+	t, ok := c.breakers.allow(r.URL.Host)
+	if !ok {
+		log.Log(
+			"failed client.Do",
+			log.ReferenceID(referenceID),
+			log.Trace(r.Context()),
+			log.User(user),
+			log.Error(ErrCircuitOpen),
+			c.loggedRequest(r, reqBody, len(reqBody)),
+		)
+		return 0, ErrCircuitOpen
+	}
+	return t, nil
+}
+
+// do sends request once, body of response is buffered (decompressed) and can be read again.
+// Response is returned with error if its body can't be read. Size of compressed body is returned too, see decompress.
+func (c *Client) do(client *http.Client, r *http.Request) (*http.Response, []byte, int, error) {
+	resp, respBody, received, err := read(client.Do(r))
+	err = classify(err)
+	hookResp := resp
+	if err != nil {
+		hookResp = nil
+	}
+	for _, hook := range c.afterResponse {
+		hook(r, hookResp, err)
+	}
+	return resp, respBody, received, err
+}
+
+func read(resp *http.Response, err error) (*http.Response, []byte, int, error) {
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var respBody []byte
+	var received int
+	if resp.Body != nil {
+		respBody, err = ioutil.ReadAll(resp.Body)
+		// Connection returns to pool only when body is closed, caller gets buffered copy of it.
+		resp.Body.Close()
+		if err != nil {
+			return resp, nil, 0, err
+		}
+		respBody, received, err = decompress(resp, respBody)
+		if err != nil {
+			return resp, nil, received, err
 		}
-		// TODO should we close body?
-		resp.Body = ioutil.NopCloser(bytes.NewBuffer(respBody))
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
 	}
+	return resp, respBody, received, nil
+}
 
-	log.Log(
-		fmt.Sprintf("out '%s %s' %d", r.Method, r.Host+r.URL.Path, resp.StatusCode),
-		log.ReferenceID(referenceID),
-		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqBody),
-		log.Response(resp.StatusCode, resp.Header, respBody),
-	)
-	return resp, nil
+// loggedRequest sets request of event redacted by rules of Client, its proxy or overridden target,
+// size is the full size of body.
+func (c *Client) loggedRequest(r *http.Request, body []byte, size int) log.SetFieldValue {
+	query := log.RedactQuery(r.URL.Query(), c.redactedQueryKeys)
+	headers := log.RedactHeaders(r.Header, c.redactedHeaders)
+	redacted := c.redactBody(body)
+	proxy, _ := c.proxy(r)
+	return func(e *log.Event) {
+		log.PartialRequest(r.Method, r.Host, r.URL.Path, query, headers, redacted, len(redacted))(e)
+		// Redacted body differs in size from the sent one.
+		if e.Request != nil {
+			e.Request.BodySize = size
+		}
+		if proxy != nil {
+			log.Context(map[string]string{"proxy": proxy.Redacted()})(e)
+		} else if target, ok := overrideHost(c.hosts, hostPort(r.URL)); ok {
+			log.Context(map[string]string{"target": target})(e)
+		}
+	}
+}
+
+// loggedResponse sets response of event redacted by rules of Client, size is the full size of body.
+func (c *Client) loggedResponse(resp *http.Response, body []byte, size int) log.SetFieldValue {
+	headers := log.RedactHeaders(resp.Header, c.redactedHeaders)
+	redacted := c.redactBody(body)
+	return func(e *log.Event) {
+		log.PartialResponse(resp.StatusCode, headers, redacted, len(redacted))(e)
+		if e.Response != nil {
+			e.Response.BodySize = size
+		}
+	}
+}
+
+func (c *Client) redactBody(body []byte) []byte {
+	if len(body) == 0 || len(c.redactedBodyKeys) == 0 {
+		return body
+	}
+	return log.RedactJSONKeys(body, c.redactedBodyKeys)
+}
+
+// failed sets error of request telling timeout from cancellation, transport errors are classified. Cancellation is logged as warning
+// without Error (notifiers are not triggered): it's not a failure of destination,
+// usually client of incoming request has disconnected.
+func failed(err error) log.SetFieldValue {
+	return func(e *log.Event) {
+		if errors.Is(err, context.Canceled) {
+			log.Context(map[string]string{"failure": "canceled", "reason": err.Error()})(e)
+			log.Warning()(e)
+			return
+		}
+		log.Error(err)(e)
+		classified(err)(e)
+		if isTimeout(err) {
+			log.Context(map[string]string{"failure": "timeout"})(e)
+		}
+	}
 }
@@ -0,0 +1,625 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"io/ioutil"
+	"lib/log"
+	"lib/metrics"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newServer returns server counting connections opened by clients.
+func newServer(handler http.HandlerFunc) (*httptest.Server, *int64) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	return srv, &conns
+}
+
+func TestConnectionReuse(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	srv, conns := newServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 10000)))
+	})
+	defer srv.Close()
+
+	c := New(Config{})
+	for i := 0; i < 5; i++ {
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		resp, err := c.Send(r, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Caller doesn't read or close buffered body.
+		_ = resp
+	}
+	for i := 0; i < 5; i++ {
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		resp, err := c.Stream(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Body read to the end is closed without Close.
+		io.Copy(ioutil.Discard, resp.Body)
+	}
+	if n := atomic.LoadInt64(conns); n != 1 {
+		t.Errorf("got %d connections, want 1", n)
+	}
+}
+
+func TestRetryIdempotency(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	var calls int64
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer srv.Close()
+
+	c := New(Config{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}})
+	tests := []struct {
+		method string
+		key    string
+		calls  int64
+	}{
+		{"GET", "", 3},
+		{"PUT", "", 3},
+		{"POST", "", 1},
+		{"POST", "key", 3},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt64(&calls, 0)
+		r, _ := http.NewRequest(tt.method, srv.URL, strings.NewReader("{}"))
+		if tt.key != "" {
+			r.Header.Set(IdempotencyKeyHeader, tt.key)
+		}
+		resp, err := c.Send(r, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s: got status %d", tt.method, resp.StatusCode)
+		}
+		if n := atomic.LoadInt64(&calls); n != tt.calls {
+			t.Errorf("%s with key %q: got %d calls, want %d", tt.method, tt.key, n, tt.calls)
+		}
+	}
+
+	// Network error of POST is returned as NotRetriedError.
+	srv.Close()
+	r, _ := http.NewRequest("POST", srv.URL, strings.NewReader("{}"))
+	_, err := c.Send(r, 0, "")
+	var notRetried *NotRetriedError
+	if !errors.As(err, &notRetried) || notRetried.Method != "POST" {
+		t.Errorf("got error %v, want NotRetriedError", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {})
+	defer srv.Close()
+
+	c := New(Config{RateLimit: RateLimitPolicy{PerHostPerSecond: 20}})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		if _, err := c.Send(r, 0, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("3 requests at 20 per second took %v", d)
+	}
+
+	c = New(Config{RateLimit: RateLimitPolicy{PerSecond: 1, NoWait: true}})
+	for i, want := range []error{nil, ErrRateLimited} {
+		r, _ := http.NewRequest("GET", srv.URL, nil)
+		if _, err := c.Send(r, 0, ""); err != want {
+			t.Errorf("request %d: got error %v, want %v", i, err, want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{now.Add(5 * time.Second).Format(http.TimeFormat), 5 * time.Second, true},
+		{now.Add(-5 * time.Second).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Retry-After": {tt.value}}}
+		delay, ok := retryAfter(resp, now)
+		if delay != tt.delay || ok != tt.ok {
+			t.Errorf("%q: got %v %v, want %v %v", tt.value, delay, ok, tt.delay, tt.ok)
+		}
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	var buf bytes.Buffer
+	log.Writer = &buf
+
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	tests := []struct {
+		url     string
+		timeout time.Duration
+		kind    error
+		name    string
+	}{
+		{srv.URL, 10 * time.Millisecond, ErrTimeout, "timeout"},
+		{closed.URL, 0, ErrConnectionRefused, "connection_refused"},
+		{"http://nonexistent.invalid", 0, ErrDNS, "dns"},
+		{tlsSrv.URL, 0, ErrTLS, "tls"},
+	}
+	c := New(Config{})
+	for _, tt := range tests {
+		buf.Reset()
+		r, _ := http.NewRequest("GET", tt.url, nil)
+		_, err := c.Send(r, tt.timeout, "")
+		if !errors.Is(err, tt.kind) {
+			t.Errorf("%s: got error %v, want %v", tt.url, err, tt.kind)
+		}
+		var e log.Event
+		json.Unmarshal(buf.Bytes(), &e)
+		if e.Error != tt.name || e.Context["error_text"] == nil {
+			t.Errorf("%s: got Error %q, context %v", tt.url, e.Error, e.Context)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte(r.URL.Query().Get("name") + string(body)))
+	})
+	defer srv.Close()
+	send := func(c *Client, name, body string) string {
+		r, _ := http.NewRequest("POST", srv.URL+"/?name="+name, strings.NewReader(body))
+		resp, err := c.Send(r, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	recorder := NewRecorder(nil)
+	c := New(Config{Transport: recorder})
+	send(c, "bob", "1")
+	send(c, "bob", "2")
+	file := t.TempDir() + "/golden.json"
+	if err := recorder.Save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Close()
+	mock, err := LoadMockTransport(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = New(Config{Transport: mock})
+	if got := send(c, "bob", "2"); got != "bob2" {
+		t.Errorf("got replayed body %q, want bob2", got)
+	}
+	if got := send(c, "bob", "1"); got != "bob1" {
+		t.Errorf("got replayed body %q, want bob1", got)
+	}
+	r, _ := http.NewRequest("GET", srv.URL, nil)
+	if _, err := c.Send(r, 0, ""); !errors.Is(err, ErrNoMockResponse) {
+		t.Errorf("got error %v, want ErrNoMockResponse", err)
+	}
+	if cookie := recorder.Interactions()[0].Header.Get("Set-Cookie"); cookie != log.Redacted {
+		t.Errorf("got recorded Set-Cookie %q", cookie)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	var fetches int64
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			id, secret, _ := r.BasicAuth()
+			if id != "orders" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			n := atomic.AddInt64(&fetches, 1)
+			fmt.Fprintf(w, `{"access_token":"t%d","expires_in":3600}`, n)
+			return
+		}
+		// The first token is revoked.
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	defer srv.Close()
+
+	c := New(Config{TokenSource: &ClientCredentials{TokenURL: srv.URL + "/token", ClientID: "orders", ClientSecret: "s3cret"}})
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("POST", srv.URL+"/orders", strings.NewReader("order"))
+		resp, err := c.Send(r, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "order" {
+			t.Errorf("request %d: got %d %q", i, resp.StatusCode, body)
+		}
+	}
+	if n := atomic.LoadInt64(&fetches); n != 2 {
+		t.Errorf("got %d fetches of token, want 2", n)
+	}
+}
+
+func TestSigners(t *testing.T) {
+	// Test vector get-vanilla of AWS Signature Version 4 test suite.
+	r, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	sigv4 := AWSSigV4{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now:             func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	if err := sigv4.Sign(r, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := r.Header.Get("Authorization"); got != want {
+		t.Errorf("got Authorization %q, want %q", got, want)
+	}
+
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Signature")))
+	})
+	defer srv.Close()
+	c := New(Config{Signers: []Signer{HMACSigner{Header: "X-Signature", Prefix: "sha256=", Secret: []byte("key")}}})
+	r, _ = http.NewRequest("POST", srv.URL, strings.NewReader("The quick brown fox jumps over the lazy dog"))
+	resp, err := c.Send(r, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"; string(body) != want {
+		t.Errorf("got signature %q, want %q", body, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	var buf bytes.Buffer
+	log.Writer = &buf
+
+	var calls, notModified int64
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt64(&notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Write([]byte("data"))
+	})
+	defer srv.Close()
+
+	c := New(Config{Cache: NewMemoryCacheStore(10)})
+	tests := []struct {
+		path        string
+		calls       int64
+		notModified int64
+		cache       []string
+	}{
+		{"/fresh", 1, 0, []string{"miss", "hit", "hit"}},
+		{"/etag", 3, 2, []string{"miss", "revalidated", "revalidated"}},
+		{"/private", 3, 0, []string{"miss", "miss", "miss"}},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt64(&calls, 0)
+		atomic.StoreInt64(&notModified, 0)
+		for i, want := range tt.cache {
+			buf.Reset()
+			r, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+			resp, err := c.Send(r, 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(body) != "data" {
+				t.Errorf("%s %d: got %d %q", tt.path, i, resp.StatusCode, body)
+			}
+			if r.Header.Get("If-None-Match") != "" {
+				t.Errorf("%s %d: conditional header is added to request of caller", tt.path, i)
+			}
+			var e log.Event
+			json.Unmarshal(buf.Bytes(), &e)
+			if e.Context["cache"] != want {
+				t.Errorf("%s %d: got cache %v, want %s", tt.path, i, e.Context["cache"], want)
+			}
+		}
+		if n := atomic.LoadInt64(&calls); n != tt.calls {
+			t.Errorf("%s: got %d calls, want %d", tt.path, n, tt.calls)
+		}
+		if n := atomic.LoadInt64(&notModified); n != tt.notModified {
+			t.Errorf("%s: got %d responses 304, want %d", tt.path, n, tt.notModified)
+		}
+	}
+
+	// Caller revalidating its own copy gets 304, not cached response.
+	r, _ := http.NewRequest("GET", srv.URL+"/etag", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+	resp, err := c.Send(r, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("got status %d to conditional request of caller, want 304", resp.StatusCode)
+	}
+
+	// Responses to requests authorized by TokenSource are private unless they are public.
+	atomic.StoreInt64(&calls, 0)
+	c = New(Config{Cache: NewMemoryCacheStore(10), TokenSource: staticToken("t")})
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", srv.URL+"/fresh", nil)
+		if _, err := c.Send(r, 0, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("got %d calls with TokenSource, want 2", n)
+	}
+}
+
+type staticToken string
+
+func (t staticToken) Token(ctx context.Context, rejected string) (string, error) {
+	return string(t), nil
+}
+
+func TestBreaker(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	var buf bytes.Buffer
+	log.Writer = &buf
+
+	b := newBreakers(BreakerPolicy{FailureRate: 0.5, MinRequests: 2, OpenTimeout: 50 * time.Millisecond})
+	state := func() circuitState {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.circuits["h"].state
+	}
+
+	// Request allowed before circuit opens finishes after it's half-open.
+	stale, _ := b.allow("h")
+	for i := 0; i < 2; i++ {
+		tk, _ := b.allow("h")
+		b.record("h", tk, true)
+	}
+	if state() != open {
+		t.Fatalf("got state %s after failures, want open", state())
+	}
+	if _, ok := b.allow("h"); ok {
+		t.Error("open circuit allowed request")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	trial, ok := b.allow("h")
+	if !ok || state() != halfOpen {
+		t.Fatalf("got state %s after open timeout, want half-open", state())
+	}
+	if _, ok := b.allow("h"); ok {
+		t.Error("half-open circuit allowed the second request")
+	}
+	b.record("h", stale, false)
+	if state() != halfOpen {
+		t.Errorf("got state %s after stale success, want half-open", state())
+	}
+	b.record("h", trial, false)
+	if state() != closed {
+		t.Errorf("got state %s after trial success, want closed", state())
+	}
+	for _, change := range []string{`"from":"closed","host":"h","to":"open"`, `"from":"open","host":"h","to":"half-open"`, `"from":"half-open","host":"h","to":"closed"`} {
+		if !strings.Contains(buf.String(), change) {
+			t.Errorf("state change %s isn't logged: %s", change, buf.String())
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	var buf bytes.Buffer
+	log.Writer = &buf
+
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, _ = gzip.NewReader(r.Body)
+		}
+		data, _ := ioutil.ReadAll(body)
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(data)
+		zw.Close()
+	})
+	defer srv.Close()
+
+	c := New(Config{GzipRequestsOver: 100})
+	for _, size := range []int{10, 1000} {
+		buf.Reset()
+		data := strings.Repeat("a", size)
+		r, _ := http.NewRequest("POST", srv.URL, strings.NewReader(data))
+		// Transport doesn't decompress response to request with Accept-Encoding set by caller.
+		r.Header.Set("Accept-Encoding", "gzip")
+		resp, err := c.Send(r, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != data || resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("size %d: got body of %d bytes, Content-Encoding %q", size, len(body), resp.Header.Get("Content-Encoding"))
+		}
+		var e log.Event
+		json.Unmarshal(buf.Bytes(), &e)
+		_, compressed := e.Context["request_compressed_bytes"]
+		if compressed != (size >= 100) || e.Context["response_compressed_bytes"] == nil || e.Response.BodySize != size {
+			t.Errorf("size %d: got context %v, response body size %d", size, e.Context, e.Response.BodySize)
+		}
+	}
+}
+
+func TestStatusAsError(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Repeat("e", 1000)))
+	})
+	defer srv.Close()
+
+	c := New(Config{StatusAsError: true})
+	for _, status := range []int{200, 302, 404, 503} {
+		r, _ := http.NewRequest("GET", srv.URL+"/?status="+strconv.Itoa(status), nil)
+		resp, err := c.Send(r, 0, "")
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) != (status >= 400) {
+			t.Errorf("status %d: got error %v", status, err)
+			continue
+		}
+		if resp == nil || resp.StatusCode != status {
+			t.Errorf("status %d: got response %v", status, resp)
+		}
+		if statusErr != nil && (statusErr.StatusCode != status || statusErr.Header.Get("Retry-After") != "1" || len(statusErr.Body) != ErrorBodyLimit) {
+			t.Errorf("status %d: got %+v", status, statusErr)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	var buf bytes.Buffer
+	log.Writer = &buf
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.TLS.ServerName))
+	}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	// Certificate of test server is valid for example.com.
+	target := srv.Listener.Addr().String()
+	c := New(Config{TLS: TLSConfig{RootCAs: roots}, Hosts: map[string]string{"example.com": target}})
+	r, _ := http.NewRequest("GET", "https://example.com/", nil)
+	resp, err := c.Send(r, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "example.com example.com" {
+		t.Errorf("got Host and server name %q", body)
+	}
+	var e log.Event
+	json.Unmarshal(buf.Bytes(), &e)
+	if e.Context["target"] != target {
+		t.Errorf("got target %v, want %s", e.Context["target"], target)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = ioutil.Discard
+
+	var calls int64
+	srv, _ := newServer(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer srv.Close()
+	host := srv.Listener.Addr().String()
+
+	c := New(Config{Retry: RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}})
+	r, _ := http.NewRequest("DELETE", srv.URL, nil)
+	if _, err := c.Send(r, 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		counter prometheus.Collector
+		want    float64
+	}{
+		{metrics.ClientRequests.WithLabelValues(host, "DELETE", "503"), 1},
+		{metrics.ClientRequests.WithLabelValues(host, "DELETE", "200"), 1},
+		{metrics.ClientRetries.WithLabelValues(host, "DELETE"), 1},
+	} {
+		if got := testutil.ToFloat64(tt.counter); got != tt.want {
+			t.Errorf("got %v, want %v", got, tt.want)
+		}
+	}
+}
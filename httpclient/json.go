@@ -0,0 +1,117 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestOption customizes request built by NewJSONRequest, GetJSON and PostJSON.
+type RequestOption func(b *requestBuilder)
+
+type requestBuilder struct {
+	query  url.Values
+	header http.Header
+	params map[string]string
+}
+
+// Query adds query parameter to URL of request.
+func Query(key, value string) RequestOption {
+	return func(b *requestBuilder) { b.query.Add(key, value) }
+}
+
+// Header adds header to request.
+func Header(key, value string) RequestOption {
+	return func(b *requestBuilder) { b.header.Add(key, value) }
+}
+
+// PathParam replaces {name} in URL of request by escaped value:
+//   httpclient.GetJSON(ctx, "http://person/v1/person/{name}", &p, httpclient.PathParam("name", name))
+func PathParam(name, value string) RequestOption {
+	return func(b *requestBuilder) { b.params["{"+name+"}"] = url.PathEscape(value) }
+}
+
+// NewJSONRequest builds request with body marshalled to JSON (no body if it's nil)
+// and Accept header of JSON.
+func NewJSONRequest(ctx context.Context, method, rawURL string, body interface{}, opts ...RequestOption) (*http.Request, error) {
+	b := requestBuilder{query: url.Values{}, header: http.Header{}, params: map[string]string{}}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	for name, value := range b.params {
+		rawURL = strings.ReplaceAll(rawURL, name, value)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.query) > 0 {
+		q := u.Query()
+		for k, vs := range b.query {
+			q[k] = append(q[k], vs...)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range b.header {
+		r.Header[k] = vs
+	}
+	if body != nil && r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if r.Header.Get("Accept") == "" {
+		r.Header.Set("Accept", "application/json")
+	}
+	return r, nil
+}
+
+// GetJSON sends GET request by SendContext and decodes JSON body of 2xx response into out.
+// Response with other status is returned with *StatusError, see DecodeJSON.
+func (c *Client) GetJSON(ctx context.Context, rawURL string, out interface{}, opts ...RequestOption) (*http.Response, error) {
+	return c.sendJSON(ctx, http.MethodGet, rawURL, nil, out, opts)
+}
+
+// PostJSON sends POST request with body marshalled to JSON, see GetJSON. Nil out skips decoding.
+func (c *Client) PostJSON(ctx context.Context, rawURL string, body, out interface{}, opts ...RequestOption) (*http.Response, error) {
+	return c.sendJSON(ctx, http.MethodPost, rawURL, body, out, opts)
+}
+
+// GetJSON sends request by DefaultClient, see Client.GetJSON.
+func GetJSON(ctx context.Context, rawURL string, out interface{}, opts ...RequestOption) (*http.Response, error) {
+	return DefaultClient.GetJSON(ctx, rawURL, out, opts...)
+}
+
+// PostJSON sends request by DefaultClient, see Client.PostJSON.
+func PostJSON(ctx context.Context, rawURL string, body, out interface{}, opts ...RequestOption) (*http.Response, error) {
+	return DefaultClient.PostJSON(ctx, rawURL, body, out, opts...)
+}
+
+func (c *Client) sendJSON(ctx context.Context, method, rawURL string, body, out interface{}, opts []RequestOption) (*http.Response, error) {
+	r, err := NewJSONRequest(ctx, method, rawURL, body, opts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.SendContext(ctx, r)
+	if err != nil {
+		// Response is returned with StatusError, see Config.StatusAsError.
+		return resp, err
+	}
+	return resp, DecodeJSON(resp, out)
+}
@@ -0,0 +1,26 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"lib/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// observe records attempt of request in metrics of package metrics, responses from cache are not recorded.
+func observe(r *http.Request, resp *http.Response, err error, latency time.Duration) {
+	status := "error"
+	var transportErr *TransportError
+	switch {
+	case errors.As(err, &transportErr):
+		status = errorNames[transportErr.Kind]
+	case errors.Is(err, context.Canceled):
+		status = "canceled"
+	case err == nil:
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.ClientRequests.WithLabelValues(r.URL.Host, r.Method, status).Inc()
+	metrics.ClientDuration.WithLabelValues(r.URL.Host, r.Method, status).Observe(latency.Seconds())
+}
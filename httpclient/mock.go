@@ -0,0 +1,164 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"lib/log"
+	"net/http"
+	"sync"
+)
+
+// ErrNoMockResponse is returned by MockTransport for request which matches no interaction.
+var ErrNoMockResponse = errors.New("no mock response")
+
+// Interaction is canned response to matching request, golden files have JSON list of them.
+type Interaction struct {
+	Method string `json:"method"`
+	// Full URL with query, e.g. http://example.org/person?fields=name.
+	URL string `json:"url"`
+	// Body of request, empty body matches any body.
+	Body string `json:"body,omitempty"`
+
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header,omitempty"`
+	ResponseBody string      `json:"response_body,omitempty"`
+}
+
+// MockTransport makes code using Client testable offline: it answers requests with canned responses
+// instead of sending them. Pass it to Client by Config.Transport:
+//   mock := httpclient.NewMockTransport(httpclient.Interaction{
+//       Method: "GET", URL: "http://example.org/person/bob", StatusCode: 200, ResponseBody: `{"name":"bob"}`,
+//   })
+//   httpclient.DefaultClient = httpclient.New(httpclient.Config{Transport: mock})
+// The first matching interaction answers request, interactions can be used many times.
+// Request matching no interaction fails with ErrNoMockResponse.
+type MockTransport struct {
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+func NewMockTransport(interactions ...Interaction) *MockTransport {
+	return &MockTransport{interactions: interactions}
+}
+
+// LoadMockTransport replays interactions of golden file saved by Recorder.
+func LoadMockTransport(file string) (*MockTransport, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("invalid golden file %s: %w", file, err)
+	}
+	return NewMockTransport(interactions...), nil
+}
+
+// Add adds interaction, it's matched after the ones added before.
+func (m *MockTransport) Add(i Interaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interactions = append(m.interactions, i)
+}
+
+func (m *MockTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, i := range m.interactions {
+		if i.Method == r.Method && i.URL == r.URL.String() && (i.Body == "" || i.Body == string(body)) {
+			return i.response(r), nil
+		}
+	}
+	return nil, fmt.Errorf("%w for %s %s", ErrNoMockResponse, r.Method, r.URL.Redacted())
+}
+
+func (i Interaction) response(r *http.Request) *http.Response {
+	return newResponse(r, i.StatusCode, i.Header.Clone(), []byte(i.ResponseBody))
+}
+
+// Recorder records transactions sent through it to save them as golden file for MockTransport:
+//   recorder := httpclient.NewRecorder(nil)
+//   client := httpclient.New(httpclient.Config{Transport: recorder})
+//   ... // run test against real destination
+//   recorder.Save("testdata/person.json")
+// Values of DefaultRedactedHeaders in responses are redacted, check bodies for secrets before commit.
+type Recorder struct {
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns Recorder sending requests by next, http.DefaultTransport if it's nil.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{next: next}
+}
+
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rec.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.interactions = append(rec.interactions, Interaction{
+		Method:       r.Method,
+		URL:          r.URL.String(),
+		Body:         string(body),
+		StatusCode:   resp.StatusCode,
+		Header:       log.RedactHeaders(resp.Header, DefaultRedactedHeaders).Clone(),
+		ResponseBody: string(respBody),
+	})
+	return resp, nil
+}
+
+// Interactions returns transactions recorded so far.
+func (rec *Recorder) Interactions() []Interaction {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Interaction(nil), rec.interactions...)
+}
+
+// Save writes recorded transactions to golden file.
+func (rec *Recorder) Save(file string) error {
+	data, err := json.MarshalIndent(rec.Interactions(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// readRequestBody reads body of request and replaces it with buffered copy.
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
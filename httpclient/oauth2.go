@@ -0,0 +1,114 @@
+package httpclient
+
+import (
+	"context"
+	"lib/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource provides access tokens of Client, they are sent in Authorization header as Bearer tokens.
+// When destination answers 401, request is sent once again with new token (body is buffered),
+// this retry is not counted by RetryPolicy.
+type TokenSource interface {
+	// Token returns access token. Cached token is returned unless it expires or equals rejected token,
+	// which destination has answered 401 to.
+	Token(ctx context.Context, rejected string) (string, error)
+}
+
+// ClientCredentials fetches and caches tokens by OAuth2 client credentials grant (RFC 6749, section 4.4):
+//   httpclient.New(httpclient.Config{TokenSource: &httpclient.ClientCredentials{
+//       TokenURL:     "https://auth.example.org/oauth/token",
+//       ClientID:     "orders",
+//       ClientSecret: os.Getenv("ORDERS_CLIENT_SECRET"),
+//   }})
+// Credentials are sent in Authorization header (basic auth), so they are redacted in log.
+// Fetched token is logged with reason of fetch: "missing", "expired" or "rejected".
+// Concurrent requests wait for one fetch. ClientCredentials is safe for concurrent use.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Client fetching tokens, client with default config is used if it's nil.
+	// It must not use this ClientCredentials.
+	Client *Client
+	// Token is refreshed so long before it expires, 10 seconds by default.
+	ExpiryDelta time.Duration
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenClient fetches tokens if ClientCredentials has no Client, DefaultClient may use token source itself.
+var tokenClient = New(Config{})
+
+func (cc *ClientCredentials) Token(ctx context.Context, rejected string) (string, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delta := cc.ExpiryDelta
+	if delta == 0 {
+		delta = 10 * time.Second
+	}
+	var reason string
+	switch {
+	case cc.token == "":
+		reason = "missing"
+	case cc.token == rejected:
+		reason = "rejected"
+	case !cc.expiry.IsZero() && time.Now().Add(delta).After(cc.expiry):
+		reason = "expired"
+	default:
+		return cc.token, nil
+	}
+	if err := cc.fetch(ctx, reason); err != nil {
+		return "", err
+	}
+	return cc.token, nil
+}
+
+func (cc *ClientCredentials) fetch(ctx context.Context, reason string) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.Scopes, " "))
+	}
+	r, err := http.NewRequestWithContext(ctx, "POST", cc.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(url.QueryEscape(cc.ClientID), url.QueryEscape(cc.ClientSecret))
+
+	client := cc.Client
+	if client == nil {
+		client = tokenClient
+	}
+	resp, err := client.Send(r, 0, "")
+	if err != nil {
+		return err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := DecodeJSON(resp, &token); err != nil {
+		return err
+	}
+	cc.token = token.AccessToken
+	cc.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		cc.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	log.Log(
+		"refreshed OAuth2 token",
+		log.ReferenceID(log.ReferenceIDFromContext(ctx)),
+		log.Trace(ctx),
+		log.Context(map[string]string{"token_url": cc.TokenURL, "client_id": cc.ClientID, "reason": reason}),
+		log.Int("expires_in", token.ExpiresIn),
+	)
+	return nil
+}
@@ -0,0 +1,49 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// phases tracks phase of request to tell which one timed out.
+// Hooks of httptrace are called by goroutines of transport.
+type phases struct {
+	mu    sync.Mutex
+	phase string
+}
+
+func (p *phases) set(phase string) {
+	p.mu.Lock()
+	p.phase = phase
+	p.mu.Unlock()
+}
+
+func (p *phases) current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase
+}
+
+// trace returns copy of request whose phases are tracked.
+func (p *phases) trace(r *http.Request) *http.Request {
+	p.set("connection")
+	return r.WithContext(httptrace.WithClientTrace(r.Context(), &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { p.set("dns") },
+		ConnectStart:         func(string, string) { p.set("connect") },
+		TLSHandshakeStart:    func() { p.set("tls_handshake") },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { p.set("connection") },
+		GotConn:              func(httptrace.GotConnInfo) { p.set("request") },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { p.set("response_header") },
+		GotFirstResponseByte: func() { p.set("response_body") },
+	}))
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
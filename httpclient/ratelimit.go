@@ -0,0 +1,121 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned without sending request when rate limit doesn't let it go in time:
+// immediately with RateLimitPolicy.NoWait, or if deadline of request comes before its turn.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitPolicy limits rate of requests with token bucket, e.g. to keep batch job within limits of third-party API.
+// Requests over limit wait for their turn (each attempt of retried request too) until context of request is done.
+// Waits longer than LogWait are logged, so slow job can be explained.
+type RateLimitPolicy struct {
+	// Requests per second to all hosts on average, zero means no limit.
+	PerSecond float64
+	// Requests to all hosts which can be sent at once, 1 by default.
+	Burst int
+	// Requests per second to each host on average, zero means no limit.
+	PerHostPerSecond float64
+	// Requests to each host which can be sent at once, 1 by default.
+	PerHostBurst int
+	// NoWait makes request over limit fail with ErrRateLimited instead of waiting.
+	NoWait bool
+	// Waits longer than it are logged, 1 second by default.
+	LogWait time.Duration
+}
+
+func (p *RateLimitPolicy) setDefaults() {
+	if p.Burst == 0 {
+		p.Burst = 1
+	}
+	if p.PerHostBurst == 0 {
+		p.PerHostBurst = 1
+	}
+	if p.LogWait == 0 {
+		p.LogWait = time.Second
+	}
+}
+
+type rateLimiters struct {
+	policy RateLimitPolicy
+	client *rate.Limiter
+
+	mu sync.Mutex
+	// Client calls a few hosts, so limiters of hosts are never removed.
+	hosts map[string]*rate.Limiter
+}
+
+func newRateLimiters(policy RateLimitPolicy) *rateLimiters {
+	policy.setDefaults()
+	l := &rateLimiters{policy: policy, hosts: make(map[string]*rate.Limiter)}
+	if policy.PerSecond > 0 {
+		l.client = rate.NewLimiter(rate.Limit(policy.PerSecond), policy.Burst)
+	}
+	return l
+}
+
+// wait blocks until request to host can be sent, it returns duration of wait.
+func (l *rateLimiters) wait(ctx context.Context, host string) (time.Duration, error) {
+	var waited time.Duration
+	for _, limiter := range []*rate.Limiter{l.client, l.host(host)} {
+		if limiter == nil {
+			continue
+		}
+		d, err := l.reserve(ctx, limiter)
+		waited += d
+		if err != nil {
+			return waited, err
+		}
+	}
+	return waited, nil
+}
+
+func (l *rateLimiters) host(host string) *rate.Limiter {
+	if l.policy.PerHostPerSecond <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.hosts[host]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(l.policy.PerHostPerSecond), l.policy.PerHostBurst)
+		l.hosts[host] = limiter
+	}
+	return limiter
+}
+
+// Token of canceled wait is returned to bucket, other requests can use it.
+func (l *rateLimiters) reserve(ctx context.Context, limiter *rate.Limiter) (time.Duration, error) {
+	start := time.Now()
+	res := limiter.ReserveN(start, 1)
+	if !res.OK() {
+		return 0, ErrRateLimited
+	}
+	delay := res.DelayFrom(start)
+	if delay == 0 {
+		return 0, nil
+	}
+	if l.policy.NoWait {
+		res.CancelAt(start)
+		return 0, ErrRateLimited
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(start) < delay {
+		res.CancelAt(start)
+		return 0, ErrRateLimited
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		res.Cancel()
+		return time.Since(start), ctx.Err()
+	}
+}
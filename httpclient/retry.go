@@ -0,0 +1,139 @@
+package httpclient
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries requests failed by network errors or with retryable status codes,
+// waiting with exponential backoff and full jitter between attempts. Body of request is buffered,
+// so it's sent again as is. Each attempt is logged with its number.
+// Only idempotent requests are retried: GET, HEAD, OPTIONS, TRACE, PUT and DELETE,
+// or requests with Idempotency-Key header set by caller. Failed request of other methods (e.g. POST)
+// may have made side effects at destination, so it's not retried to avoid duplicates:
+// its error is *NotRetriedError, response with retryable status is returned as is.
+type RetryPolicy struct {
+	// Attempts including the first one, zero or one means no retries.
+	MaxAttempts int
+	// Delay before the first retry, it doubles for each next one. 100 milliseconds by default.
+	BaseDelay time.Duration
+	// MaxDelay caps delay including one requested by Retry-After header, 10 seconds by default.
+	// Retry-After (seconds or HTTP date) of response replaces backoff, it's logged as retry_after_ms
+	// next to honored delay retry_delay_ms.
+	MaxDelay time.Duration
+	// Status codes of responses to retry, 429, 502, 503 and 504 by default.
+	Statuses []int
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.BaseDelay == 0 {
+		p.BaseDelay = 100 * time.Millisecond
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = 10 * time.Second
+	}
+	if p.Statuses == nil {
+		p.Statuses = []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}
+	}
+}
+
+// delay returns delay before the next attempt, false if request must not be retried.
+// Request isn't retried if its context is done or deadline comes before the next attempt.
+func (p *RetryPolicy) delay(r *http.Request, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || r.Context().Err() != nil {
+		return 0, false
+	}
+	if err == nil && !p.retryable(resp.StatusCode) {
+		return 0, false
+	}
+
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	d = time.Duration(rand.Int63n(int64(d) + 1))
+	if after, ok := retryAfter(resp, time.Now()); ok {
+		d = after
+		if d > p.MaxDelay {
+			d = p.MaxDelay
+		}
+	}
+
+	if deadline, ok := r.Context().Deadline(); ok && time.Until(deadline) < d {
+		return 0, false
+	}
+	return d, true
+}
+
+// retryAfter returns delay requested by Retry-After header of response: number of seconds or HTTP date.
+// Date in the past means no delay.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+func (p *RetryPolicy) retryable(status int) bool {
+	for _, s := range p.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// IdempotencyKeyHeader makes request of non-idempotent method safe to retry,
+// destination must process requests with the same key once.
+var IdempotencyKeyHeader = "Idempotency-Key"
+
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// NotRetriedError is returned for failed request which would be retried if it was idempotent.
+// Request may or may not have reached destination, caller decides whether to send it again:
+//   var notRetried *httpclient.NotRetriedError
+//   if errors.As(err, &notRetried) { ... }
+type NotRetriedError struct {
+	Method string
+	URL    string
+	Err    error
+}
+
+func (e *NotRetriedError) Error() string {
+	return fmt.Sprintf("not retried non-idempotent request %s %s: %v", e.Method, e.URL, e.Err)
+}
+
+func (e *NotRetriedError) Unwrap() error {
+	return e.Err
+}
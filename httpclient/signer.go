@@ -0,0 +1,180 @@
+package httpclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signer signs request before each attempt, after body is buffered and other headers (token, BeforeRequest hooks)
+// are set, so signature covers the final request. Body is nil for request sent by Stream: its body can't be read twice.
+type Signer interface {
+	Sign(r *http.Request, body []byte) error
+}
+
+// SignerFunc makes function Signer.
+type SignerFunc func(r *http.Request, body []byte) error
+
+func (f SignerFunc) Sign(r *http.Request, body []byte) error {
+	return f(r, body)
+}
+
+// errStreamedBody is returned by signers which need body of request sent by Stream.
+var errStreamedBody = errors.New("can't sign body of streamed request")
+
+// HMACSigner puts HMAC signature of body in header, it's verified by middleware.HMAC with the same settings:
+//   httpclient.HMACSigner{Header: "X-Signature-256", Prefix: "sha256=", Secret: secret}
+// Add Header to Config.RedactedHeaders: signature with logged body lets anyone with access to log replay request.
+type HMACSigner struct {
+	Header string
+	Prefix string
+	// Hash is sha256.New by default.
+	Hash func() hash.Hash
+	// Signature is encoded in hex by default.
+	Base64 bool
+	Secret []byte
+}
+
+func (s HMACSigner) Sign(r *http.Request, body []byte) error {
+	if body == nil && r.Body != nil && r.Body != http.NoBody {
+		return errStreamedBody
+	}
+	h := s.Hash
+	if h == nil {
+		h = sha256.New
+	}
+	mac := hmac.New(h, s.Secret)
+	mac.Write(body)
+	sum := mac.Sum(nil)
+	signature := hex.EncodeToString(sum)
+	if s.Base64 {
+		signature = base64.StdEncoding.EncodeToString(sum)
+	}
+	r.Header.Set(s.Header, s.Prefix+signature)
+	return nil
+}
+
+// AWSSigV4 signs requests to AWS APIs by Signature Version 4
+// (https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html):
+//   httpclient.AWSSigV4{AccessKeyID: id, SecretAccessKey: secret, Region: "eu-west-1", Service: "execute-api"}
+// Body of streamed request is not signed (UNSIGNED-PAYLOAD), it's accepted by S3.
+// Signature is in Authorization header, it's redacted in log by default.
+type AWSSigV4 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken of temporary credentials, it's sent in X-Amz-Security-Token header.
+	SessionToken string
+	Region       string
+	Service      string
+
+	// now is time.Now by default, tests sign with fixed time.
+	now func() time.Time
+}
+
+func (s AWSSigV4) Sign(r *http.Request, body []byte) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	scope := strings.Join([]string{t.Format("20060102"), s.Region, s.Service, "aws4_request"}, "/")
+
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if body != nil || r.Body == nil || r.Body == http.NoBody {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	r.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	// S3 requires hash of payload in header, other services compute it themselves.
+	if s.Service == "s3" {
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range r.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-type" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		awsQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{t.Format("20060102"), s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	r.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature,
+	))
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsQuery encodes query sorted by keys and values, spaces are encoded as %20.
+func awsQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
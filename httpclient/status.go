@@ -0,0 +1,90 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// ErrorBodyLimit limits excerpt of response body in StatusError.
+var ErrorBodyLimit = 512
+
+// StatusError is error of response with unexpected status code.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Headers of response, e.g. Retry-After.
+	Header http.Header
+	// The beginning of response body, see ErrorBodyLimit.
+	Body string
+	// Reference id of request, its log events are found by it.
+	ReferenceID string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d of '%s %s': %s", e.StatusCode, e.Method, e.URL, e.Body)
+}
+
+// ExpectStatus returns *StatusError if status code of response is not one of statuses, any 2xx if none given:
+//   var statusErr *httpclient.StatusError
+//   if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+// Body of response can still be read.
+func ExpectStatus(resp *http.Response, statuses ...int) error {
+	if len(statuses) == 0 && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	for _, s := range statuses {
+		if resp.StatusCode == s {
+			return nil
+		}
+	}
+	return newStatusError(resp)
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	e := &StatusError{StatusCode: resp.StatusCode, Header: resp.Header}
+	if r := resp.Request; r != nil {
+		e.Method, e.URL = r.Method, r.URL.Host+r.URL.Path
+		e.ReferenceID = r.Header.Get(ReferenceIDHeader)
+	}
+	if resp.Body != nil {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if len(body) > ErrorBodyLimit {
+			body = body[:ErrorBodyLimit]
+		}
+		e.Body = string(body)
+	}
+	return e
+}
+
+// DecodeJSON checks status code of response by ExpectStatus and decodes its JSON body into out.
+// Body is closed in any case.
+//   resp, err := httpclient.SendContext(ctx, r)
+//   if err != nil {
+//       return err
+//   }
+//   var p person
+//   if err := httpclient.DecodeJSON(resp, &p); err != nil {
+func DecodeJSON(resp *http.Response, out interface{}, statuses ...int) error {
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	if err := ExpectStatus(resp, statuses...); err != nil {
+		return err
+	}
+	if out == nil || resp.Body == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("unmarshal body of '%s %s': %w", resp.Request.Method, resp.Request.URL.Host+resp.Request.URL.Path, err)
+	}
+	return nil
+}
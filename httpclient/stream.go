@@ -0,0 +1,136 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"lib/log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stream sends request without buffering bodies, e.g. to upload or download large file.
+// Caller must close body of response, body read to the end is closed automatically. Deadline of ctx limits the whole transfer,
+// timeout of Client doesn't apply: it would cut long download.
+// Request is not retried, its body can't be sent again.
+// Transaction event is logged when body of response is closed, it has sizes of bodies
+// and headers (content types), but not bodies themselves.
+func (c *Client) Stream(ctx context.Context, r *http.Request) (*http.Response, error) {
+	r = r.WithContext(ctx)
+	referenceID := log.ReferenceIDFromContext(ctx)
+	user := log.UserFromContext(ctx)
+	propagate(r, referenceID)
+	t, err := c.admit(r, referenceID, user, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var sent *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		sent = &countingReader{r: r.Body}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{sent, r.Body}
+	}
+
+	client := http.Client{Transport: c.transport}
+	start := time.Now()
+	resp, err := client.Do(r)
+	err = classify(err)
+	if err != nil {
+		observe(r, nil, err, time.Since(start))
+	}
+	if err != nil && ctx.Err() != nil {
+		c.breakers.release(r.URL.Host, t)
+	} else {
+		c.breakers.record(r.URL.Host, t, err != nil || resp.StatusCode >= 500)
+	}
+	for _, hook := range c.afterResponse {
+		hook(r, resp, err)
+	}
+	if err != nil {
+		log.Log(
+			"failed client.Do",
+			log.ReferenceID(referenceID),
+			log.Trace(ctx),
+			log.User(user),
+			failed(err),
+			c.loggedRequest(r, nil, sent.count()),
+			log.Latency(time.Since(start)),
+		)
+		return nil, err
+	}
+
+	received := &countingReader{r: resp.Body}
+	resp.Body = &streamBody{Reader: received, body: resp.Body, onClose: func(readErr error) {
+		observe(r, resp, classify(readErr), time.Since(start))
+		setters := []log.SetFieldValue{
+			log.ReferenceID(referenceID),
+			log.Trace(ctx),
+			log.User(user),
+			c.loggedRequest(r, nil, sent.count()),
+			c.loggedResponse(resp, nil, received.count()),
+			log.Latency(time.Since(start)),
+			log.Bool("streamed", true),
+		}
+		if readErr != nil {
+			setters = append(setters, failed(classify(readErr)))
+		}
+		log.Log(fmt.Sprintf("out '%s %s' %d", r.Method, r.Host+r.URL.Path, resp.StatusCode), setters...)
+	}}
+	return resp, nil
+}
+
+// Stream sends request by DefaultClient, see Client.Stream.
+func Stream(ctx context.Context, r *http.Request) (*http.Response, error) {
+	return DefaultClient.Stream(ctx, r)
+}
+
+// streamBody logs transaction once, when it's closed. Error of reading, other than EOF, is logged with it.
+// It's closed on EOF, so connection returns to pool even if caller forgets to close it.
+type streamBody struct {
+	io.Reader
+	body    io.Closer
+	onClose func(readErr error)
+
+	once    sync.Once
+	readErr error
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.Close()
+	} else if err != nil && b.readErr == nil {
+		b.readErr = err
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() { b.onClose(b.readErr) })
+	return err
+}
+
+// Body of request is read by goroutine of transport, so count is atomic.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingReader) count() int {
+	if c == nil {
+		return 0
+	}
+	return int(c.n.Load())
+}
@@ -0,0 +1,60 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"lib/log"
+)
+
+// TLSConfig configures TLS of connections to destinations, e.g. internal services with private PKI:
+//   roots, err := httpclient.LoadRootCAs("/etc/pki/internal-ca.pem")
+//   cert, err := tls.LoadX509KeyPair("/etc/pki/client.pem", "/etc/pki/client-key.pem")
+//   httpclient.New(httpclient.Config{TLS: httpclient.TLSConfig{RootCAs: roots, Certificates: []tls.Certificate{cert}}})
+type TLSConfig struct {
+	// CAs which certificates of destinations are verified by, system ones by default.
+	RootCAs *x509.CertPool
+	// Certificates of client for mutual TLS.
+	Certificates []tls.Certificate
+	// Minimal version of TLS, tls.VersionTLS12 by default.
+	MinVersion uint16
+
+	// InsecureSkipVerify disables verification of certificates of destinations:
+	// anyone in the middle can read and change traffic. Never use it in production,
+	// Client logs warning when it's created with it.
+	InsecureSkipVerify bool
+}
+
+// LoadRootCAs returns system CAs with CAs from PEM files added.
+func LoadRootCAs(files ...string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+func (c TLSConfig) tlsConfig() *tls.Config {
+	if c.MinVersion == 0 {
+		c.MinVersion = tls.VersionTLS12
+	}
+	if c.InsecureSkipVerify {
+		log.Log("TLS verification is disabled in httpclient", log.Warning())
+	}
+	return &tls.Config{
+		RootCAs:            c.RootCAs,
+		Certificates:       c.Certificates,
+		MinVersion:         c.MinVersion,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+}
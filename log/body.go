@@ -0,0 +1,124 @@
+package log
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Binary body (image, protobuf...) written to JSON string is unreadable garbage.
+// By default only its content type and size are logged.
+// If Base64Body is set, binary body is logged base64-encoded with "base64:" prefix,
+// BodyLimit and TruncateBody are applied to encoded body.
+var Base64Body = false
+
+// Body is binary if it's not valid UTF-8 text or contains control characters
+// which are not expected in text (all except tab, newline and carriage return).
+func isBinary(body []byte) bool {
+	if !utf8.Valid(body) {
+		return true
+	}
+	for _, c := range body {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// Body might be captured partially, size is the full size of body.
+func formatBinaryBody(body []byte, size int, headers http.Header) string {
+	if !Base64Body {
+		return fmt.Sprintf("not logged: binary body (%s, %d bytes)", contentType(body, headers), size)
+	}
+	limit := base64.StdEncoding.DecodedLen(BodyLimit)
+	if size > limit {
+		if TruncateBody {
+			if limit > len(body) {
+				limit = len(body)
+			}
+			return fmt.Sprintf("base64:%s...truncated (%d of %d bytes)", base64.StdEncoding.EncodeToString(body[:limit]), limit, size)
+		}
+		return fmt.Sprintf("not logged: body size (%d bytes) is bigger than limit (%d bytes)", size, limit)
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(body)
+}
+
+// Content-Type header is preferred, as detection by content recognizes only common formats.
+func contentType(body []byte, headers http.Header) string {
+	if ct := headers.Get("Content-Type"); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(body)
+}
+
+// Options of body normalization, they are applied before BodyLimit.
+// Customize them for project in init function.
+var (
+	// Control characters (newlines, tabs...) are replaced with escape sequences (\n, \t, \u001b),
+	// so body is saved in log database as one line of text.
+	EscapeBodyControlChars = false
+	// Runs of whitespace (including newlines) are replaced with single space.
+	// Useful for pretty-printed XML.
+	CollapseBodyWhitespace = false
+	// JSON body is compacted: insignificant whitespace is removed, strings are kept intact.
+	// Body which is not valid JSON is logged as is.
+	CompactJSONBody = false
+)
+
+func normalizeBody(body []byte) []byte {
+	if CompactJSONBody {
+		var b bytes.Buffer
+		if err := json.Compact(&b, body); err == nil {
+			body = b.Bytes()
+		}
+	}
+	if CollapseBodyWhitespace {
+		body = []byte(strings.Join(strings.Fields(string(body)), " "))
+	}
+	if EscapeBodyControlChars {
+		body = escapeControlChars(body)
+	}
+	return body
+}
+
+func escapeControlChars(p []byte) []byte {
+	if bytes.IndexFunc(p, isControl) < 0 {
+		return p
+	}
+	var b bytes.Buffer
+	for _, r := range string(p) {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case isControl(r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.Bytes()
+}
+
+// Line and paragraph separators are treated as newlines by some viewers.
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f || r == '\u2028' || r == '\u2029'
+}
+
+// Writers expect one event per line. JSON encoder never produces newlines,
+// but other encoders might, e.g. console encoder writes message as is.
+func singleLine(log []byte) []byte {
+	if bytes.IndexAny(log, "\r\n") < 0 {
+		return log
+	}
+	log = bytes.ReplaceAll(log, []byte("\r"), []byte(`\r`))
+	return bytes.ReplaceAll(log, []byte("\n"), []byte(`\n`))
+}
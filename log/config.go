@@ -0,0 +1,130 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Levels of events in ascending order, see Event.Level.
+var levels = map[string]int32{"debug": 0, "info": 1, "warning": 2, "error": 3}
+
+// Events with level below minLevel are dropped. It's "debug" by default: nothing is dropped.
+var minLevel int32
+
+// SetMinLevel sets minimal level of events to write: "debug", "info", "warning" or "error".
+// Events of this package have level "info" or "error" (see Event.Level),
+// so "warning" and "error" leave only errors and warnings of slog.
+// It's safe to change level at runtime.
+func SetMinLevel(level string) error {
+	l, ok := levels[level]
+	if !ok {
+		return fmt.Errorf("unknown level %q", level)
+	}
+	atomic.StoreInt32(&minLevel, l)
+	return nil
+}
+
+func MinLevel() string {
+	l := atomic.LoadInt32(&minLevel)
+	for name, v := range levels {
+		if v == l {
+			return name
+		}
+	}
+	return ""
+}
+
+func enabled(e *Event) bool {
+	return levels[e.level()] >= atomic.LoadInt32(&minLevel)
+}
+
+// Bodies of requests and responses are logged by default, 1 is true.
+var logBodies int32 = 1
+
+// SetLogBodies turns logging of request and response bodies on and off,
+// e.g. to debug integration in production for a while.
+// It's safe to change it at runtime.
+func SetLogBodies(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&logBodies, v)
+}
+
+func LogBodies() bool {
+	return atomic.LoadInt32(&logBodies) == 1
+}
+
+type config struct {
+	Level      *string  `json:"level,omitempty"`
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+	LogBodies  *bool    `json:"log_bodies,omitempty"`
+}
+
+// ConfigHandler lets operators view and change configuration of logging at runtime, without restart.
+// Mount it under admin path, it has no authentication:
+//   mux.Handle("/admin/log", adminAuth(log.ConfigHandler()))
+// GET returns current configuration, PUT (or POST) changes fields present in JSON body:
+//   {"level": "error", "sample_rate": 0.1, "log_bodies": false}
+// Changes are logged.
+func ConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var c config
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				writeConfigError(w, err)
+				return
+			}
+			if err := applyConfig(c); err != nil {
+				writeConfigError(w, err)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		level, rate, bodies := MinLevel(), SampleRate(), LogBodies()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config{Level: &level, SampleRate: &rate, LogBodies: &bodies})
+	})
+}
+
+// Configuration is validated as a whole before it's applied.
+func applyConfig(c config) error {
+	if c.Level != nil {
+		if _, ok := levels[*c.Level]; !ok {
+			return fmt.Errorf("unknown level %q", *c.Level)
+		}
+	}
+	if c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1) {
+		return fmt.Errorf("sample_rate %v is out of range [0, 1]", *c.SampleRate)
+	}
+
+	changes := make(map[string]string)
+	if c.Level != nil {
+		SetMinLevel(*c.Level)
+		changes["level"] = *c.Level
+	}
+	if c.SampleRate != nil {
+		SetSampleRate(*c.SampleRate)
+		changes["sample_rate"] = fmt.Sprint(*c.SampleRate)
+	}
+	if c.LogBodies != nil {
+		SetLogBodies(*c.LogBodies)
+		changes["log_bodies"] = fmt.Sprint(*c.LogBodies)
+	}
+	Log("changed log config", Context(changes))
+	return nil
+}
+
+func writeConfigError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,100 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Console renders event as one human-readable line:
+//   15:04:05.000 INFO    in 'POST localhost:8080/person/boris' 201 reference_id=abc (req POST localhost:8080/person/boris 27B, resp 201 18B)
+// Colors are disabled if NO_COLOR environment variable is set (https://no-color.org).
+// Not intended for production: the line can't be parsed back into event.
+func Console(e *Event) ([]byte, error) {
+	var b bytes.Buffer
+
+	ts := e.Timestamp
+	if t, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil {
+		ts = t.Local().Format("15:04:05.000")
+	}
+	b.WriteString(paint(colorGray, ts))
+	b.WriteByte(' ')
+
+	lvl := strings.ToUpper(e.level())
+	color := colorGreen
+	switch {
+	case lvl == "ERROR" || e.Error != "":
+		color = colorRed
+	case lvl == "WARNING":
+		color = colorYellow
+	case lvl == "DEBUG":
+		color = colorGray
+	}
+	b.WriteString(paint(color, fmt.Sprintf("%-7s", lvl)))
+	b.WriteByte(' ')
+	b.WriteString(paint(colorBold, e.Message))
+
+	if e.Error != "" {
+		writePair(&b, "error", e.Error)
+	}
+	if e.ReferenceID != "" {
+		writePair(&b, "reference_id", e.ReferenceID)
+	}
+	if e.User != "" {
+		writePair(&b, "user", e.User)
+	}
+	if e.Tenant != "" {
+		writePair(&b, "tenant", e.Tenant)
+	}
+
+	for _, k := range sortedKeys(e.Context) {
+		writePair(&b, k, formatValue(e.Context[k]))
+	}
+
+	// Request and response are summarized, their bodies can be found in JSON logs.
+	var summary []string
+	if e.Request != nil {
+		r := e.Request
+		summary = append(summary, fmt.Sprintf("req %s %s %dB", r.Method, r.Host+r.Path, r.BodySize))
+	}
+	if e.Response != nil {
+		summary = append(summary, fmt.Sprintf("resp %d %dB", e.Response.StatusCode, e.Response.BodySize))
+	}
+	if e.LatencyMS != 0 {
+		summary = append(summary, fmt.Sprintf("%.1fms", e.LatencyMS))
+	}
+	if len(summary) > 0 {
+		b.WriteString(paint(colorGray, " ("+strings.Join(summary, ", ")+")"))
+	}
+	return b.Bytes(), nil
+}
+
+func writePair(b *bytes.Buffer, key, value string) {
+	b.WriteByte(' ')
+	b.WriteString(paint(colorCyan, key+"="))
+	if strings.ContainsAny(value, " \t\n\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	b.WriteString(value)
+}
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+	colorBold   = "\x1b[1m"
+	colorReset  = "\x1b[0m"
+)
+
+var noColor = os.Getenv("NO_COLOR") != ""
+
+func paint(color, s string) string {
+	if noColor {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+// Keys of context values are unexported typed constants,
+// so they never collide with keys of other packages.
+type contextKey int
+
+const (
+	referenceIDKey contextKey = iota
+	userKey
+	tenantKey
+)
+
+// WithReferenceID returns copy of ctx carrying reference id, see FromContext.
+func WithReferenceID(ctx context.Context, referenceID string) context.Context {
+	return context.WithValue(ctx, referenceIDKey, referenceID)
+}
+
+// WithUser returns copy of ctx carrying user, see FromContext.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// WithTenant returns copy of ctx carrying tenant, see FromContext.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+func ReferenceIDFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(referenceIDKey).(string)
+	return v
+}
+
+func UserFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(userKey).(string)
+	return v
+}
+
+func TenantFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(tenantKey).(string)
+	return v
+}
+
+// FromContext sets reference id, user, tenant and trace carried by ctx (see middleware package).
+func FromContext(ctx context.Context) SetFieldValue {
+	return func(e *Event) {
+		if v := ReferenceIDFromContext(ctx); v != "" {
+			e.ReferenceID = v
+		}
+		if v := UserFromContext(ctx); v != "" {
+			e.User = v
+		}
+		if v := TenantFromContext(ctx); v != "" {
+			e.Tenant = v
+		}
+		Trace(ctx)(e)
+	}
+}
+
+// Middlewares and handlers might add fields to event of HTTP transaction,
+// which is logged by another middleware (see middleware.RequestResponseLogger).
+// They are collected in context of request.
+type fields struct {
+	mu      sync.Mutex
+	setters []SetFieldValue
+}
+
+type fieldsKey struct{}
+
+// WithFields returns copy of ctx carrying collector of fields for event of transaction, see AddFields.
+// If ctx already carries collector, ctx is returned as is.
+func WithFields(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(fieldsKey{}).(*fields); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, fieldsKey{}, &fields{})
+}
+
+// AddFields adds fields to event of transaction. Setters are applied when event is logged,
+// so they may set values which are not known yet (e.g. size of body which is not read yet).
+// Fields are not added if ctx has no collector (see WithFields).
+func AddFields(ctx context.Context, setters ...SetFieldValue) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	f.setters = append(f.setters, setters...)
+	f.mu.Unlock()
+}
+
+// Fields sets fields added to ctx by AddFields.
+func Fields(ctx context.Context) SetFieldValue {
+	return func(e *Event) {
+		f, ok := ctx.Value(fieldsKey{}).(*fields)
+		if !ok {
+			return
+		}
+		f.mu.Lock()
+		setters := f.setters
+		f.mu.Unlock()
+		for _, set := range setters {
+			set(e)
+		}
+	}
+}
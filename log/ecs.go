@@ -0,0 +1,69 @@
+package log
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const ecsVersion = "8.11.0"
+
+// ECS renders event as JSON with field names of Elastic Common Schema
+// (https://www.elastic.co/guide/en/ecs/current/index.html), so Kibana dashboards work without ingest pipelines.
+// Fields which have no ECS counterpart (reference_id, context, headers) are kept under their own names.
+func ECS(e *Event) ([]byte, error) {
+	m := map[string]interface{}{
+		"@timestamp":  e.Timestamp,
+		"ecs.version": ecsVersion,
+		"log.level":   e.level(),
+		"message":     e.Message,
+	}
+	set := func(key, value string) {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	set("error.message", e.Error)
+	set("error.code", e.ErrorCode)
+	set("user.name", e.User)
+	set("organization.id", e.Tenant)
+	set("trace.id", e.TraceID)
+	set("span.id", e.SpanID)
+	set("host.hostname", e.Hostname)
+	set("service.name", e.Service)
+	set("service.environment", e.Environment)
+	set("service.version", e.Version)
+	set("reference_id", e.ReferenceID)
+	if len(e.Context) > 0 {
+		m["context"] = e.Context
+	}
+	if r := e.Request; r != nil {
+		set("http.request.method", r.Method)
+		set("url.domain", r.Host)
+		set("url.path", r.Path)
+		set("url.query", r.Query.Encode())
+		set("http.request.body.content", r.Body)
+		set("client.ip", r.ClientIP)
+		set("http.route", r.Route)
+		if r.BodySize != 0 {
+			m["http.request.body.bytes"] = r.BodySize
+		}
+		if len(r.Headers) > 0 {
+			m["http.request.headers"] = r.Headers
+		}
+	}
+	if r := e.Response; r != nil {
+		m["http.response.status_code"] = r.StatusCode
+		set("http.response.body.content", r.Body)
+		if r.BodySize != 0 {
+			m["http.response.body.bytes"] = r.BodySize
+		}
+		if len(r.Headers) > 0 {
+			m["http.response.headers"] = r.Headers
+		}
+	}
+	if e.LatencyMS != 0 {
+		// ECS duration is in nanoseconds.
+		m["event.duration"] = int64(e.LatencyMS * float64(time.Millisecond))
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,42 @@
+package log
+
+import "errors"
+
+// Error text is free-form and often contains details (ids, addresses), so events with
+// the same cause can't be grouped by it. Registered errors are classified: Error field
+// gets stable short name ("db_timeout"), ErrorCode gets code, and the text of error
+// is saved in context as "error_text".
+// Register errors for project in init function:
+//   log.RegisterError("db_timeout", "DB001", context.DeadlineExceeded)
+//   log.RegisterErrorFunc("db_duplicate", "DB002", isDuplicateKey)
+// The first matching registration wins.
+
+type errorClass struct {
+	name  string
+	code  string
+	match func(error) bool
+}
+
+var errorClasses []errorClass
+
+// RegisterError classifies errors matching target by errors.Is.
+func RegisterError(name, code string, target error) {
+	RegisterErrorFunc(name, code, func(err error) bool {
+		return errors.Is(err, target)
+	})
+}
+
+// RegisterErrorFunc classifies errors for which match returns true.
+// Use errors.As in match to classify errors by type.
+func RegisterErrorFunc(name, code string, match func(error) bool) {
+	errorClasses = append(errorClasses, errorClass{name: name, code: code, match: match})
+}
+
+func classifyError(err error) (errorClass, bool) {
+	for _, c := range errorClasses {
+		if c.match(err) {
+			return c, true
+		}
+	}
+	return errorClass{}, false
+}
@@ -0,0 +1,79 @@
+package log
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed setters add value to Context keeping its JSON type,
+// so log database can filter and aggregate by it (e.g. "context.rows > 1000").
+// Use them only for values which are going to be queried, for everything else use Context.
+
+func Int(key string, value int) SetFieldValue {
+	return func(e *Event) {
+		e.setContext(key, value)
+	}
+}
+
+func Bool(key string, value bool) SetFieldValue {
+	return func(e *Event) {
+		e.setContext(key, value)
+	}
+}
+
+func Float(key string, value float64) SetFieldValue {
+	return func(e *Event) {
+		e.setContext(key, value)
+	}
+}
+
+// Duration is saved as number of milliseconds (with fraction), it's the most common unit
+// to query latency by. Suffix key with "_ms" to make the unit clear.
+func Duration(key string, value time.Duration) SetFieldValue {
+	return func(e *Event) {
+		e.setContext(key, milliseconds(value))
+	}
+}
+
+// Time is saved in the same format as Timestamp: RFC3339, UTC timezone.
+func Time(key string, value time.Time) SetFieldValue {
+	return func(e *Event) {
+		e.setContext(key, value.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+func Strings(key string, values []string) SetFieldValue {
+	return func(e *Event) {
+		e.setContext(key, values)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Value of Context in encoders which don't support types.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GCPProject is used to build trace resource name for Cloud Logging:
+//   projects/{GCPProject}/traces/{TraceID}
+// By default it's taken from GOOGLE_CLOUD_PROJECT environment variable.
+var GCPProject = os.Getenv("GOOGLE_CLOUD_PROJECT")
+
+// GCP renders event as JSON in Google Cloud Logging structured format
+// (https://cloud.google.com/logging/docs/structured-logging):
+// severity, time, trace and httpRequest fields are recognized by Cloud Logging,
+// all other fields of event are saved in jsonPayload as is.
+func GCP(e *Event) ([]byte, error) {
+	g := gcpEvent{
+		Severity: strings.ToUpper(e.level()),
+		Time:     e.Timestamp,
+		SpanID:   e.SpanID,
+		Event:    e,
+	}
+	if e.TraceID != "" && GCPProject != "" {
+		g.Trace = fmt.Sprintf("projects/%s/traces/%s", GCPProject, e.TraceID)
+	}
+	if r := e.Request; r != nil {
+		g.HTTPRequest = &gcpHTTPRequest{
+			RequestMethod: r.Method,
+			RequestURL:    r.Host + r.Path,
+			RemoteIP:      r.ClientIP,
+		}
+		if r.BodySize != 0 {
+			g.HTTPRequest.RequestSize = strconv.Itoa(r.BodySize)
+		}
+		if len(r.Query) > 0 {
+			g.HTTPRequest.RequestURL += "?" + r.Query.Encode()
+		}
+		if e.Response != nil {
+			g.HTTPRequest.Status = e.Response.StatusCode
+			if e.Response.BodySize != 0 {
+				g.HTTPRequest.ResponseSize = strconv.Itoa(e.Response.BodySize)
+			}
+		}
+		if e.LatencyMS != 0 {
+			g.HTTPRequest.Latency = fmt.Sprintf("%.9fs", e.LatencyMS/1000)
+		}
+	}
+	return json.Marshal(&g)
+}
+
+type gcpEvent struct {
+	Severity    string          `json:"severity"`
+	Time        string          `json:"time"`
+	Trace       string          `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID      string          `json:"logging.googleapis.com/spanId,omitempty"`
+	HTTPRequest *gcpHTTPRequest `json:"httpRequest,omitempty"`
+
+	// Fields of embedded event with the same JSON names are hidden by these empty fields,
+	// as they are already represented by the fields above.
+	Timestamp string `json:"timestamp,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanIDHex string `json:"span_id,omitempty"`
+
+	*Event
+}
+
+type gcpHTTPRequest struct {
+	RequestMethod string `json:"requestMethod,omitempty"`
+	RequestURL    string `json:"requestUrl,omitempty"`
+	Status        int    `json:"status,omitempty"`
+	Latency       string `json:"latency,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+
+	// Sizes are int64 in Cloud Logging API, which are encoded as strings in JSON.
+	RequestSize  string `json:"requestSize,omitempty"`
+	ResponseSize string `json:"responseSize,omitempty"`
+}
@@ -26,25 +26,53 @@
 package log
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-// Body with size over BodyLimit will not be logged.
-const BodyLimit = 2 * 1 << 10
+// Body with size over BodyLimit will not be logged, unless TruncateBody is set.
+// Customize it for project in init function.
+var BodyLimit = 2 * 1 << 10
+
+// If TruncateBody is set, the first BodyLimit bytes of oversized body are logged
+// followed by "...truncated (X of Y bytes)" marker.
+var TruncateBody = false
 
 // HOSTNAME is set only in bash and is not present in environment variables (check by env command).
 // That's why os.Getenv("HOSTNAME") returns empty string.
 var HOSTNAME, _ = os.Hostname()
 
-// TODO stack trace
-type event struct {
+// Static fields are attached to every event, like HOSTNAME.
+// Set them for project in init function, or at build time:
+//   go build -ldflags "-X lib/log.Version=$(git rev-parse --short HEAD)"
+var (
+	// Name of the service, to tell apart events of services sharing log database.
+	Service string
+	// Deploy environment: production, staging...
+	Environment string
+	// Version of the service: git commit, tag...
+	Version string
+)
+
+// Version of event structure. It's incremented on incompatible changes:
+// renamed or removed fields, changed meaning or type of field.
+const SchemaVersion = 1
+
+// Event is a structure of each log, see Decode to parse it back.
+type Event struct {
+	// See SchemaVersion.
+	SchemaVersion int `json:"schema_version"`
+
 	// Short description of event. Details are provided by other fields.
 	// Examples:
 	//
@@ -81,6 +109,11 @@ type event struct {
 	//     Do not write path query, as it might increase message length and clutter it with unnecessary details.
 	Message string `json:"message"`
 
+	// There are no levels of logging in this package: event is either an error (see Error) or not.
+	// Level is set only for events of other loggers, e.g. slog ("debug", "info", "warning", "error"),
+	// and for warnings about misuse of library (see Warning).
+	Level string `json:"level,omitempty"`
+
 	// Should be set by logs sender, not by logs receiver.
 	// Format: RFC3339, UTC timezone.
 	Timestamp string `json:"timestamp"`
@@ -90,31 +123,52 @@ type event struct {
 	//  - user can reference specific request, as reference id is supposed to be sent to user in case of errors.
 	ReferenceID string `json:"reference_id,omitempty"`
 
+	// Ids of distributed trace and its span (W3C Trace Context format, hex encoded).
+	// Unlike ReferenceID they are not shown to user, they join event with trace in tracing backend.
+	TraceID string `json:"trace_id,omitempty"`
+	SpanID  string `json:"span_id,omitempty"`
+
 	// Most of the requests are not made anonymously.
 	// To improve readability of request and speed of debug,
 	// because there will be no need to identify user of request by e.g. token in headers or request body.
 	User string `json:"user,omitempty"`
 
+	// Tenant (customer organization) of multi-tenant service, on whose behalf request is made.
+	// Used to debug and analyze usage per tenant.
+	Tenant string `json:"tenant,omitempty"`
+
 	// Short name of the error, without details.
 	// Used by notifiers: if error exists then notifier will send notification.
 	Error string `json:"error,omitempty"`
 
+	// Stable code of classified error, see RegisterError.
+	ErrorCode string `json:"error_code,omitempty"`
+
 	// String representation of function arguments, constants...
 	// Type of value should be identified from the code where event happened.
-	// No need to save numbers as integers:
-	//   - log database is unlikely to be used to calculate values.
+	// Save numbers, booleans, durations and times with typed setters (Int, Bool, Float, Duration, Time, Strings)
+	// only if log database is used to filter or aggregate by them, e.g. to find slow requests.
 	// No need to serialize structures as JSON:
 	//   - many structures are not intended to be represented as JSON,
 	//   - it's unlikely to query log database by structure field.
 	// For structure serializing prefer "%#v". NOTE: values of reference type are not readable!
-	Context map[string]string `json:"context,omitempty"`
+	Context map[string]interface{} `json:"context,omitempty"`
+
+	Request  *HTTPRequest  `json:"request,omitempty"`
+	Response *HTTPResponse `json:"response,omitempty"`
 
-	Request  *request  `json:"request,omitempty"`
-	Response *response `json:"response,omitempty"`
+	// Duration of HTTP transaction in milliseconds: handling of request for 'in' transaction,
+	// sending request and reading response for 'out' transaction.
+	LatencyMS float64 `json:"latency_ms,omitempty"`
 
 	// In kubernetes HOSTNAME is equal to pod's name.
 	// In docker-compose use 'hostname' param to set HOSTNAME  inside container.
 	Hostname string `json:"hostname,omitempty"`
+
+	// See static fields Service, Environment and Version.
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Version     string `json:"version,omitempty"`
 }
 
 // Customize Writer for project in init function.
@@ -127,105 +181,360 @@ func Log(message string, setters ...SetFieldValue) {
 	if Writer == nil {
 		return
 	}
-	e := event{
+	e := newEvent(message, time.Now())
+	for _, set := range setters {
+		set(&e)
+	}
+	write(&e)
+}
+
+func newEvent(message string, t time.Time) Event {
+	return Event{
+		SchemaVersion: SchemaVersion,
+
 		Message:   message,
-		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Timestamp: t.UTC().Format(time.RFC3339Nano),
 		Hostname:  HOSTNAME,
+
+		Service:     Service,
+		Environment: Environment,
+		Version:     Version,
 	}
-	for _, set := range setters {
-		set(&e)
+}
+
+func write(e *Event) {
+	if !enabled(e) {
+		atomic.AddUint64(&stats.Dropped, 1)
+		return
+	}
+	for _, hook := range Hooks {
+		if !hook(e) {
+			atomic.AddUint64(&stats.Dropped, 1)
+			return
+		}
 	}
-	// If there is a need to improve performance, create encoder for event structure.
-	// It's possible to avoid using reflection in encoder because we know types of each value
-	// in event structure in advance.
-	log, err := json.Marshal(&e)
+	log, err := Encode(e)
 	if err != nil {
-		log = []byte(fmt.Sprintf(`{"message": "failed json.Marshal", "error": %q, "reference_id": %q, "context": {"event": "%#v"}}`, err, e.ReferenceID, e))
+		log = []byte(fmt.Sprintf(`{"message": "failed json.Marshal", "error": %q, "reference_id": %q, "context": {"event": "%#v"}}`, err, e.ReferenceID, *e))
 	}
+	log = singleLine(log)
 	if _, err = Writer.Write(log); err != nil {
-		fmt.Printf(`{"message": "failed l.Writer.Write", "error": %q, "reference_id": %q, "context": {"data": %q}}`, err, e.ReferenceID, string(log))
+		atomic.AddUint64(&stats.WriteFailures, 1)
+		if OnWriteError != nil {
+			OnWriteError(err, e, log)
+		}
+		return
+	}
+	atomic.AddUint64(&stats.Written, 1)
+}
+
+// Flusher is implemented by sinks which buffer events: async writers, batchers, notifiers.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// FlushFunc adapts function to Flusher.
+type FlushFunc func(ctx context.Context) error
+
+func (f FlushFunc) Flush(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Flushers are flushed by Close in order, after Writer.
+// Customize Flushers for project in init function, same as Writer.
+var Flushers []Flusher
+
+// Close flushes Writer (if it's a Flusher) and Flushers, so the last events
+// are not lost on shutdown. Flushing is stopped when ctx is done.
+// Events logged after Close are still written.
+func Close(ctx context.Context) error {
+	var errs []error
+	if f, ok := Writer.(Flusher); ok {
+		errs = append(errs, f.Flush(ctx))
+	}
+	for _, f := range Flushers {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		errs = append(errs, f.Flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// OnWriteError is called when Writer fails to write encoded event.
+// By default event is printed to stdout, which is the last resort for broken Writer.
+// Customize it for project in init function, e.g. to alert about broken sink.
+// NOTE: OnWriteError must be concurrently safe.
+var OnWriteError = func(err error, e *Event, data []byte) {
+	fmt.Printf(`{"message": "failed l.Writer.Write", "error": %q, "reference_id": %q, "context": {"data": %q}}`+"\n", err, e.ReferenceID, string(data))
+}
+
+// Statistics of events since start of the process.
+// Growth of WriteFailures means that Writer is broken.
+type Statistics struct {
+	// Events successfully passed to Writer.
+	Written uint64
+	// Events dropped by level or hooks (sampling, filters...).
+	Dropped uint64
+	// Events which Writer failed to write.
+	WriteFailures uint64
+}
+
+var stats Statistics
+
+func Stats() Statistics {
+	return Statistics{
+		Written:       atomic.LoadUint64(&stats.Written),
+		Dropped:       atomic.LoadUint64(&stats.Dropped),
+		WriteFailures: atomic.LoadUint64(&stats.WriteFailures),
+	}
+}
+
+type SetFieldValue func(*Event)
+
+// Decode parses event written by JSON encoder, e.g. for tests or tools processing logs.
+// Event of unknown (newer) schema version or without message and timestamp is invalid.
+func Decode(data []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, err
+	}
+	if e.SchemaVersion < 1 || e.SchemaVersion > SchemaVersion {
+		return e, fmt.Errorf("unsupported schema_version %d", e.SchemaVersion)
+	}
+	if e.Message == "" || e.Timestamp == "" {
+		return e, errors.New("message and timestamp are required")
+	}
+	return e, nil
+}
+
+// Hook is called for each event before it's encoded.
+// It may change event (add fields, scrub sensitive data) or drop it by returning false.
+// Hooks are extension point for sampling and notifiers.
+type Hook func(*Event) bool
+
+// Hooks are called in order, the first one returning false drops event.
+// Customize Hooks for project in init function, same as Writer.
+// NOTE: Hook must be concurrently safe.
+var Hooks []Hook
+
+// Encode serializes event before it's passed to Writer.
+// By default it's chosen by LOG_FORMAT environment variable:
+//   json    - one JSON object per line (default),
+//   console - colored human-readable line, for local development only,
+//   logfmt  - one line of key=value pairs,
+//   gcp     - JSON in Google Cloud Logging structured format,
+//   ecs     - JSON with Elastic Common Schema field names.
+// Customize Encode for project in init function, same as Writer.
+var Encode EncodeFunc = encoderFromEnv()
+
+type EncodeFunc func(*Event) ([]byte, error)
+
+func encoderFromEnv() EncodeFunc {
+	switch os.Getenv("LOG_FORMAT") {
+	case "console":
+		return Console
+	case "logfmt":
+		return Logfmt
+	case "gcp":
+		return GCP
+	case "ecs":
+		return ECS
+	default:
+		return JSON
 	}
 }
 
-type SetFieldValue func(*event)
+// If there is a need to improve performance, create encoder for event structure.
+// It's possible to avoid using reflection in encoder because we know types of each value
+// in event structure in advance.
+func JSON(e *Event) ([]byte, error) {
+	return json.Marshal(e)
+}
 
 func ReferenceID(referenceID string) SetFieldValue {
-	return func(e *event) {
+	return func(e *Event) {
 		e.ReferenceID = referenceID
 	}
 }
 
+// Registered errors are classified, see RegisterError.
 func Error(err error) SetFieldValue {
-	return func(e *event) {
-		e.Error = err.Error()
+	return func(e *Event) {
+		c, ok := classifyError(err)
+		if !ok {
+			e.Error = err.Error()
+			return
+		}
+		e.Error = c.name
+		e.ErrorCode = c.code
+		e.setContext("error_text", err.Error())
 	}
 }
 
 func User(user string) SetFieldValue {
-	return func(e *event) {
+	return func(e *Event) {
 		e.User = user
 	}
 }
 
+func Tenant(tenant string) SetFieldValue {
+	return func(e *Event) {
+		e.Tenant = tenant
+	}
+}
+
+func Latency(d time.Duration) SetFieldValue {
+	return func(e *Event) {
+		e.LatencyMS = milliseconds(d)
+	}
+}
+
 func Context(cnt map[string]string) SetFieldValue {
-	return func(e *event) {
+	return func(e *Event) {
 		// json.Marshal(nil) == "null"
 		// "context" field is expected to be JSON object, so we skip initializing it with string "null".
 		if cnt == nil {
 			return
 		}
-		e.Context = cnt
+		for k, v := range cnt {
+			e.setContext(k, v)
+		}
 	}
 }
 
-type request struct {
-	Method string     `json:"method,omitempty"`
-	Host   string     `json:"host,omitempty"`
-	Path   string     `json:"path,omitempty"`
-	Query  url.Values `json:"query,omitempty"`
+func (e *Event) setContext(key string, value interface{}) {
+	if e.Context == nil {
+		e.Context = make(map[string]interface{})
+	}
+	e.Context[key] = value
+}
+
+// Level is derived for encoders which need it.
+// Warning marks event about something wrong which is not an error yet,
+// e.g. misuse of library by code of project. Notifiers are not triggered by warnings.
+func Warning() SetFieldValue {
+	return func(e *Event) {
+		e.Level = "warning"
+	}
+}
+
+func (e *Event) level() string {
+	if e.Level != "" {
+		return e.Level
+	}
+	if e.Error != "" {
+		return "error"
+	}
+	return "info"
+}
+
+// HTTPRequest is set by Request.
+type HTTPRequest struct {
+	Method string `json:"method,omitempty"`
+	Host   string `json:"host,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// Values of sensitive keys are redacted, see RedactedQueryKeys.
+	Query url.Values `json:"query,omitempty"`
 
 	// Multiple header values are joined by comma.
 	Headers map[string]string `json:"headers,omitempty"`
 
 	Body string `json:"body,omitempty"`
+
+	// Size of body in bytes, it's set even if body itself is not logged (too big, binary...).
+	BodySize int `json:"body_size,omitempty"`
+	// Number of the first bytes of body captured for logging, it's set only if body is captured partially.
+	// Total size of body is BodySize.
+	CapturedBytes int `json:"captured_bytes,omitempty"`
+
+	// IP address of client, it's set by ClientIP.
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// Template of path ("/person/:name") to aggregate events by endpoint, it's set by Route.
+	Route string `json:"route,omitempty"`
 }
 
 func Request(method, host, path string, query url.Values, headers http.Header, body []byte) SetFieldValue {
-	return func(e *event) {
+	return PartialRequest(method, host, path, query, headers, body, len(body))
+}
+
+// PartialRequest is the same as Request, but only the beginning of body is captured,
+// e.g. by middleware which streams request body to handler. Size is the full size of body.
+func PartialRequest(method, host, path string, query url.Values, headers http.Header, body []byte, size int) SetFieldValue {
+	return func(e *Event) {
 		// To avoid logging of empty object, it's value must be nil.
-		if method == "" && host == "" && path == "" && len(query) == 0 && len(headers) == 0 && len(body) == 0 {
+		if method == "" && host == "" && path == "" && len(query) == 0 && len(headers) == 0 && size == 0 {
 			return
 		}
-		e.Request = &request{
-			Method:  method,
-			Host:    host,
-			Path:    path,
-			Query:   query,
-			Headers: formatHeaders(headers),
-			Body:    formatBody(body),
+		e.Request = &HTTPRequest{
+			Method:        method,
+			Host:          host,
+			Path:          path,
+			Query:         redactQuery(query),
+			Headers:       formatHeaders(headers),
+			Body:          formatBody(body, size, headers),
+			BodySize:      size,
+			CapturedBytes: capturedBytes(body, size),
+		}
+	}
+}
+
+// ClientIP sets IP address of client in request of event, so it must follow Request.
+func ClientIP(ip string) SetFieldValue {
+	return func(e *Event) {
+		if e.Request != nil {
+			e.Request.ClientIP = ip
+		}
+	}
+}
+
+// Route sets route of request of event, so it must follow Request.
+func Route(route string) SetFieldValue {
+	return func(e *Event) {
+		if e.Request != nil {
+			e.Request.Route = route
 		}
 	}
 }
 
-type response struct {
+// HTTPResponse is set by Response.
+type HTTPResponse struct {
 	StatusCode int `json:"status_code"`
 
 	// Multiple header values are joined by comma.
 	Headers map[string]string `json:"headers,omitempty"`
 
 	Body string `json:"body,omitempty"`
+
+	// Size of body in bytes, it's set even if body itself is not logged (too big, binary...).
+	BodySize int `json:"body_size,omitempty"`
+	// Number of the first bytes of body captured for logging, it's set only if body is captured partially.
+	// Total size of body is BodySize.
+	CapturedBytes int `json:"captured_bytes,omitempty"`
 }
 
 func Response(statusCode int, headers http.Header, body []byte) SetFieldValue {
-	return func(e *event) {
+	return PartialResponse(statusCode, headers, body, len(body))
+}
+
+// PartialResponse is the same as Response, but only the beginning of body is captured,
+// e.g. by middleware which streams response to client. Size is the full size of body.
+// Capture at least BodyLimit bytes, otherwise body will be logged as truncated.
+func PartialResponse(statusCode int, headers http.Header, body []byte, size int) SetFieldValue {
+	return func(e *Event) {
 		// To avoid logging of empty object, it's value must be nil.
-		if statusCode == 0 && len(headers) == 0 && len(body) == 0 {
+		if statusCode == 0 && len(headers) == 0 && size == 0 {
 			return
 		}
-		e.Response = &response{
-			StatusCode: statusCode,
-			Headers:    formatHeaders(headers),
-			Body:       formatBody(body),
+		e.Response = &HTTPResponse{
+			StatusCode:    statusCode,
+			Headers:       formatHeaders(headers),
+			Body:          formatBody(body, size, headers),
+			BodySize:      size,
+			CapturedBytes: capturedBytes(body, size),
 		}
 	}
 }
@@ -236,23 +545,84 @@ func formatHeaders(headers http.Header) map[string]string {
 	}
 	h := make(map[string]string)
 	for header, values := range headers {
+		if !headerLogged(header) {
+			continue
+		}
 		h[header] = strings.Join(values, ", ")
 	}
+	if len(h) == 0 {
+		return nil
+	}
 	return h
 }
 
-// TODO what if body has newlines, how it will be saved in mongodb?
-func formatBody(body []byte) string {
+// capturedBytes is size of captured beginning of body if it's captured partially, zero otherwise.
+func capturedBytes(body []byte, size int) int {
+	if len(body) < size {
+		return len(body)
+	}
+	return 0
+}
+
+// Newlines of body are escaped in JSON, so event is always one line.
+// In log database body is saved as multiline text, set EscapeBodyControlChars to keep it in one line.
+// Body might be captured partially, size is the full size of body.
+func formatBody(body []byte, size int, headers http.Header) string {
 	b := ""
-	if len(body) == 0 {
+	// Body might be not captured at all, only its size is known.
+	if size == 0 || len(body) == 0 {
 		return b
 	}
-	if len(body) > BodyLimit {
-		return fmt.Sprintf("not logged: body size (%d bytes) is bigger than limit (%d bytes)", len(body), BodyLimit)
+	if !LogBodies() {
+		return fmt.Sprintf("not logged: logging of bodies is off (%d bytes)", size)
+	}
+	partial := len(body) < size
+	if partial {
+		// The last rune might be cut by capture.
+		body = body[:runeBoundary(body, len(body))]
+	}
+	if isBinary(body) {
+		return formatBinaryBody(body, size, headers)
+	}
+	// Partial body can't be normalized as a whole, it's logged only truncated.
+	if !partial {
+		body = normalizeBody(body)
+		size = len(body)
+	}
+	if size > BodyLimit {
+		if TruncateBody {
+			return truncateBody(body, BodyLimit, size)
+		}
+		return fmt.Sprintf("not logged: body size (%d bytes) is bigger than limit (%d bytes)", size, BodyLimit)
 	}
 	return string(body)
 }
 
+func truncateBody(body []byte, limit, size int) string {
+	n := runeBoundary(body, limit)
+	return fmt.Sprintf("%s...truncated (%d of %d bytes)", body[:n], n, size)
+}
+
+// Body is cut at rune boundary, otherwise the last character might be broken.
+// If body is cut at its end, incomplete last rune (left by partial capture) is cut off.
+func runeBoundary(body []byte, n int) int {
+	if n < len(body) {
+		for n > 0 && !utf8.RuneStart(body[n]) {
+			n--
+		}
+		return n
+	}
+	n = len(body)
+	i := n - 1
+	for i > 0 && !utf8.RuneStart(body[i]) {
+		i--
+	}
+	if i < 0 || utf8.FullRune(body[i:]) {
+		return n
+	}
+	return i
+}
+
 type stdout struct{}
 
 // Newline is appended, otherwise all logs will be written as one line.
@@ -1,6 +1,16 @@
 package log
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
 
 func BenchmarkLog(b *testing.B) {
 	b.RunParallel(func(pb *testing.PB) {
@@ -9,3 +19,204 @@ func BenchmarkLog(b *testing.B) {
 		}
 	})
 }
+
+func TestSamplingByReferenceID(t *testing.T) {
+	SetSampleRate(0.5)
+	defer SetSampleRate(1)
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		ref := fmt.Sprintf("ref-%d", i)
+		in := Sampling(&Event{Message: "in 'GET /'", ReferenceID: ref})
+		out := Sampling(&Event{Message: "out 'GET example.org'", ReferenceID: ref})
+		if in != out {
+			t.Fatalf("events of %s are sampled differently", ref)
+		}
+		if in {
+			kept++
+		}
+	}
+	if kept < 400 || kept > 600 {
+		t.Errorf("expected about half of references to be kept, got %d of 1000", kept)
+	}
+	if !Sampling(&Event{Error: "timeout", ReferenceID: "dropped"}) {
+		t.Error("event with error is dropped")
+	}
+}
+
+func TestFormatBody(t *testing.T) {
+	defer func(limit int, truncate bool) { BodyLimit, TruncateBody = limit, truncate }(BodyLimit, TruncateBody)
+	BodyLimit = 8
+
+	tests := []struct {
+		name     string
+		body     []byte
+		truncate bool
+		want     string
+	}{
+		{"text", []byte("abc"), false, "abc"},
+		{"over limit", []byte("abcdefghij"), false, "not logged: body size (10 bytes) is bigger than limit (8 bytes)"},
+		{"truncated", []byte("abcdefghij"), true, "abcdefgh...truncated (8 of 10 bytes)"},
+		{"truncated at rune", []byte("abcdefgЖ"), true, "abcdefg...truncated (7 of 9 bytes)"},
+		{"binary", []byte{0x89, 'P', 'N', 'G'}, false, "not logged: binary body (image/png, 4 bytes)"},
+	}
+	for _, tt := range tests {
+		TruncateBody = tt.truncate
+		headers := http.Header{}
+		if tt.name == "binary" {
+			headers.Set("Content-Type", "image/png")
+		}
+		if got := formatBody(tt.body, len(tt.body), headers); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBodySize(t *testing.T) {
+	defer func(limit int) { BodyLimit = limit }(BodyLimit)
+	BodyLimit = 2
+	var e Event
+	Response(200, nil, []byte("not logged"))(&e)
+	if e.Response.BodySize != 10 {
+		t.Errorf("got body_size %d, want 10", e.Response.BodySize)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	query := url.Values{"q": {"go"}, "Access_Token": {"secret"}}
+	var e Event
+	Request("GET", "example.org", "/search", query, nil, nil)(&e)
+	if got := e.Request.Query.Encode(); got != "Access_Token=%5BREDACTED%5D&q=go" {
+		t.Errorf("unexpected query %s", got)
+	}
+	if query.Get("Access_Token") != "secret" {
+		t.Error("query of request is modified")
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	headers := http.Header{"Authorization": {"Bearer secret"}, "Accept": {"*/*"}}
+	redacted := RedactHeaders(headers, []string{"authorization"})
+	if redacted.Get("Authorization") != Redacted || redacted.Get("Accept") != "*/*" {
+		t.Errorf("unexpected headers %v", redacted)
+	}
+	if headers.Get("Authorization") != "Bearer secret" {
+		t.Error("headers are modified")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { Writer = w }(Writer)
+	Writer = &buf
+
+	Log("in 'GET example.org/' 200", ReferenceID("ref"), Response(200, nil, []byte("ok")))
+	e, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SchemaVersion != SchemaVersion || e.ReferenceID != "ref" || e.Response.Body != "ok" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	if _, err := Decode([]byte(`{"schema_version": 99, "message": "m", "timestamp": "t"}`)); err == nil {
+		t.Error("event of unknown schema version is decoded")
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	defer func() { errorClasses = nil }()
+	RegisterError("db_timeout", "DB001", context.DeadlineExceeded)
+
+	var e Event
+	Error(fmt.Errorf("failed query 42: %w", context.DeadlineExceeded))(&e)
+	if e.Error != "db_timeout" || e.ErrorCode != "DB001" || e.Context["error_text"] != "failed query 42: context deadline exceeded" {
+		t.Errorf("unexpected classification %+v", e)
+	}
+
+	e = Event{}
+	Error(errors.New("unknown"))(&e)
+	if e.Error != "unknown" || e.ErrorCode != "" {
+		t.Errorf("unexpected classification %+v", e)
+	}
+}
+
+func TestPartialResponse(t *testing.T) {
+	defer func(limit int, truncate bool) { BodyLimit, TruncateBody = limit, truncate }(BodyLimit, TruncateBody)
+	BodyLimit, TruncateBody = 4, true
+
+	var e Event
+	// The second byte of 'Ж' is not captured.
+	PartialResponse(200, nil, []byte("abcЖ")[:4], 100)(&e)
+	if e.Response.Body != "abc...truncated (3 of 100 bytes)" || e.Response.BodySize != 100 {
+		t.Errorf("unexpected response %+v", e.Response)
+	}
+}
+
+// Encoders are tested on the same event: transaction with newline and quotes in values.
+func encoderTestEvent() *Event {
+	e := &Event{Message: "in 'POST example.org/person' 201", Timestamp: "2024-01-02T03:04:05Z"}
+	for _, set := range []SetFieldValue{
+		ReferenceID("abc"),
+		User("alice"),
+		Context(map[string]string{"note": "line1\nline2 \"quoted\""}),
+		Request("POST", "example.org", "/person", nil, nil, []byte(`{"name": "Bob"}`)),
+		Response(201, nil, []byte(`{"id": 1}`)),
+	} {
+		set(e)
+	}
+	return e
+}
+
+func TestConsole(t *testing.T) {
+	defer func(c bool) { noColor = c }(noColor)
+	noColor = true
+
+	e := encoderTestEvent()
+	line, err := Console(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, _ := time.Parse(time.RFC3339, e.Timestamp)
+	want := ts.Local().Format("15:04:05.000") + ` INFO    in 'POST example.org/person' 201 reference_id=abc user=alice note="line1\nline2 \"quoted\"" (req POST example.org/person 15B, resp 201 9B)`
+	if string(line) != want {
+		t.Errorf("got  %s\nwant %s", line, want)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	line, err := Logfmt(encoderTestEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `schema_version=0 message="in 'POST example.org/person' 201" timestamp=2024-01-02T03:04:05Z reference_id=abc user=alice context.note="line1\nline2 \"quoted\"" request.method=POST request.host=example.org request.path=/person request.body="{\"name\": \"Bob\"}" request.body_size=15 response.status_code=201 response.body="{\"id\": 1}" response.body_size=9`
+	if string(line) != want {
+		t.Errorf("got  %s\nwant %s", line, want)
+	}
+}
+
+func TestGCP(t *testing.T) {
+	defer func(p string) { GCPProject = p }(GCPProject)
+	GCPProject = "project"
+
+	e := encoderTestEvent()
+	e.TraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	line, err := GCP(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"severity":"INFO","time":"2024-01-02T03:04:05Z","logging.googleapis.com/trace":"projects/project/traces/4bf92f3577b34da6a3ce929d0e0e4736","httpRequest":{"requestMethod":"POST","requestUrl":"example.org/person","status":201,"requestSize":"15","responseSize":"9"},"schema_version":0,"message":"in 'POST example.org/person' 201","reference_id":"abc","user":"alice","context":{"note":"line1\nline2 \"quoted\""},"request":{"method":"POST","host":"example.org","path":"/person","body":"{\"name\": \"Bob\"}","body_size":15},"response":{"status_code":201,"body":"{\"id\": 1}","body_size":9}}`
+	if string(line) != want {
+		t.Errorf("got  %s\nwant %s", line, want)
+	}
+}
+
+func TestECS(t *testing.T) {
+	line, err := ECS(encoderTestEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"@timestamp":"2024-01-02T03:04:05Z","context":{"note":"line1\nline2 \"quoted\""},"ecs.version":"8.11.0","http.request.body.bytes":15,"http.request.body.content":"{\"name\": \"Bob\"}","http.request.method":"POST","http.response.body.bytes":9,"http.response.body.content":"{\"id\": 1}","http.response.status_code":201,"log.level":"info","message":"in 'POST example.org/person' 201","reference_id":"abc","url.domain":"example.org","url.path":"/person","user.name":"alice"}`
+	if string(line) != want {
+		t.Errorf("got  %s\nwant %s", line, want)
+	}
+}
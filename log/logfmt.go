@@ -0,0 +1,104 @@
+package log
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Logfmt renders event as one line of key=value pairs (https://brandur.org/logfmt).
+// Keys are the same as JSON keys of event, nested objects are flattened with dots:
+//   message="in 'GET localhost:8080/api/v1' 200" timestamp=... request.method=GET response.status_code=200
+// As in JSON, empty fields are omitted.
+func Logfmt(e *Event) ([]byte, error) {
+	var b bytes.Buffer
+	writeLogfmt(&b, "schema_version", strconv.Itoa(e.SchemaVersion))
+	writeLogfmt(&b, "message", e.Message)
+	writeLogfmt(&b, "level", e.Level)
+	writeLogfmt(&b, "timestamp", e.Timestamp)
+	writeLogfmt(&b, "reference_id", e.ReferenceID)
+	writeLogfmt(&b, "trace_id", e.TraceID)
+	writeLogfmt(&b, "span_id", e.SpanID)
+	writeLogfmt(&b, "user", e.User)
+	writeLogfmt(&b, "tenant", e.Tenant)
+	writeLogfmt(&b, "error", e.Error)
+	writeLogfmt(&b, "error_code", e.ErrorCode)
+	for _, k := range sortedKeys(e.Context) {
+		writeLogfmt(&b, "context."+k, formatValue(e.Context[k]))
+	}
+	if r := e.Request; r != nil {
+		writeLogfmt(&b, "request.method", r.Method)
+		writeLogfmt(&b, "request.host", r.Host)
+		writeLogfmt(&b, "request.path", r.Path)
+		writeLogfmt(&b, "request.query", r.Query.Encode())
+		writeLogfmtMap(&b, "request.headers.", r.Headers)
+		writeLogfmt(&b, "request.body", r.Body)
+		if r.BodySize != 0 {
+			writeLogfmt(&b, "request.body_size", strconv.Itoa(r.BodySize))
+		}
+		writeLogfmt(&b, "request.client_ip", r.ClientIP)
+		writeLogfmt(&b, "request.route", r.Route)
+	}
+	if r := e.Response; r != nil {
+		writeLogfmt(&b, "response.status_code", strconv.Itoa(r.StatusCode))
+		writeLogfmtMap(&b, "response.headers.", r.Headers)
+		writeLogfmt(&b, "response.body", r.Body)
+		if r.BodySize != 0 {
+			writeLogfmt(&b, "response.body_size", strconv.Itoa(r.BodySize))
+		}
+	}
+	if e.LatencyMS != 0 {
+		writeLogfmt(&b, "latency_ms", formatValue(e.LatencyMS))
+	}
+	writeLogfmt(&b, "hostname", e.Hostname)
+	writeLogfmt(&b, "service", e.Service)
+	writeLogfmt(&b, "environment", e.Environment)
+	writeLogfmt(&b, "version", e.Version)
+	return b.Bytes(), nil
+}
+
+// Keys of map are sorted to produce the same line for the same event.
+func writeLogfmtMap(b *bytes.Buffer, prefix string, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeLogfmt(b, prefix+k, m[k])
+	}
+}
+
+func writeLogfmt(b *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(strings.Map(logfmtKeyRune, key))
+	b.WriteByte('=')
+	if needsQuoting(value) {
+		b.WriteString(strconv.Quote(value))
+		return
+	}
+	b.WriteString(value)
+}
+
+// Key can't contain space, '=' or '"', otherwise line can't be parsed.
+func logfmtKeyRune(r rune) rune {
+	if r <= ' ' || r == '=' || r == '"' {
+		return '_'
+	}
+	return r
+}
+
+func needsQuoting(value string) bool {
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces values which must not be logged.
+const Redacted = "[REDACTED]"
+
+// Values of query parameters with these keys (case-insensitive) are replaced with Redacted
+// in every logged request: tokens and API keys are often passed in query.
+// Customize RedactedQueryKeys for project in init function.
+var RedactedQueryKeys = []string{"access_token", "refresh_token", "id_token", "token", "api_key", "apikey", "key", "password", "secret", "signature", "sig"}
+
+func redactQuery(query url.Values) url.Values {
+	return RedactQuery(query, RedactedQueryKeys)
+}
+
+// RedactQuery returns query with values of keys (case-insensitive) replaced with Redacted.
+// Query is copied, not modified in place.
+func RedactQuery(query url.Values, keys []string) url.Values {
+	if len(query) == 0 {
+		return query
+	}
+	var redacted url.Values
+	for k, values := range query {
+		if !containsFold(keys, k) {
+			continue
+		}
+		if redacted == nil {
+			redacted = make(url.Values, len(query))
+			for k, v := range query {
+				redacted[k] = v
+			}
+		}
+		r := make([]string, len(values))
+		for i := range r {
+			r[i] = Redacted
+		}
+		redacted[k] = r
+	}
+	if redacted == nil {
+		return query
+	}
+	return redacted
+}
+
+// Values of JSON object keys (case-insensitive, at any depth) which are replaced with Redacted by RedactJSON.
+// Customize RedactedBodyKeys for project in init function.
+var RedactedBodyKeys = []string{"password", "secret", "token", "access_token", "refresh_token", "api_key", "card_number", "cvv"}
+
+// RedactJSON returns body with values of RedactedBodyKeys replaced, e.g. to log body which failed validation.
+// Body which is not valid JSON is returned as is.
+func RedactJSON(body []byte) []byte {
+	return RedactJSONKeys(body, RedactedBodyKeys)
+}
+
+// RedactJSONKeys is RedactJSON with its own list of keys.
+func RedactJSONKeys(body []byte, keys []string) []byte {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return body
+	}
+	redacted, err := json.Marshal(redactValue(v, keys))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func redactValue(v interface{}, keys []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if containsFold(keys, k) {
+				v[k] = Redacted
+			} else {
+				v[k] = redactValue(value, keys)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value, keys)
+		}
+	}
+	return v
+}
+
+// RedactHeaders returns headers with values of keys (case-insensitive) replaced with Redacted,
+// unlike HeaderDenylist it shows that header was sent. Headers are copied, not modified in place.
+func RedactHeaders(headers http.Header, keys []string) http.Header {
+	var redacted http.Header
+	for k := range headers {
+		if !containsFold(keys, k) {
+			continue
+		}
+		if redacted == nil {
+			redacted = headers.Clone()
+		}
+		redacted[k] = []string{Redacted}
+	}
+	if redacted == nil {
+		return headers
+	}
+	return redacted
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers of requests and responses to log (case-insensitive).
+// If HeaderAllowlist is not empty, only listed headers are logged.
+// Headers in HeaderDenylist are never logged.
+// Logging of every header bloats events and leaks internal infrastructure details, for example:
+//   log.HeaderAllowlist = []string{"Content-Type", "Content-Length", "User-Agent", "Reference-ID"}
+//   log.HeaderDenylist = []string{"Authorization", "Cookie", "Set-Cookie", "X-Envoy-Peer-Metadata"}
+// Customize them for project in init function.
+var (
+	HeaderAllowlist []string
+	HeaderDenylist  []string
+)
+
+func headerLogged(header string) bool {
+	if len(HeaderAllowlist) > 0 && !containsFold(HeaderAllowlist, header) {
+		return false
+	}
+	return !containsFold(HeaderDenylist, header)
+}
@@ -0,0 +1,57 @@
+package log
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Sampling is a hook which keeps only SampleRate fraction of events, to reduce load of logging.
+// Decision is made per reference_id, not per event: if request is sampled, its whole chain
+// of events ("in", "out", failures...) is kept, otherwise it's dropped as a whole.
+// Events without reference_id are sampled one by one.
+// Events with error are always kept.
+// Enable it for project in init function:
+//   log.SetSampleRate(0.1)
+//   log.Hooks = append(log.Hooks, log.Sampling)
+func Sampling(e *Event) bool {
+	if e.Error != "" {
+		return true
+	}
+	rate := SampleRate()
+	if rate >= 1 {
+		return true
+	}
+	if e.ReferenceID == "" {
+		return rand.Float64() < rate
+	}
+	// The same reference_id gets the same hash in every event and on every node.
+	h := fnv.New64a()
+	h.Write([]byte(e.ReferenceID))
+	return float64(mix(h.Sum64()))/math.MaxUint64 < rate
+}
+
+// High bits of FNV hash are poorly distributed for short similar strings (e.g. sequential ids),
+// so they are mixed by finalizer of MurmurHash3.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Bits of float64, 1 by default (all events are kept).
+var sampleRate = math.Float64bits(1)
+
+// SetSampleRate sets fraction of reference ids to keep, from 0 (drop all) to 1 (keep all).
+// It's safe to change rate at runtime, see ConfigHandler.
+func SetSampleRate(rate float64) {
+	atomic.StoreUint64(&sampleRate, math.Float64bits(rate))
+}
+
+func SampleRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&sampleRate))
+}
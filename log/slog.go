@@ -0,0 +1,140 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// SlogHandler passes records of log/slog to this package, so code using slog
+// (including dependencies) emits events of the same structure to the same Writer:
+//   slog.SetDefault(slog.New(log.NewSlogHandler(slog.LevelInfo)))
+// Attributes are mapped to event fields:
+//   - "reference_id", "user" and "tenant" string attributes set fields with the same names,
+//   - attribute with error value sets Error,
+//   - all others are saved in Context with their types, groups are flattened with dots.
+// Reference id, user and trace are also taken from context of record (see middleware).
+type SlogHandler struct {
+	level  slog.Leveler
+	prefix string
+	attrs  []slog.Attr
+}
+
+// Records below level are dropped, nil level means slog.LevelInfo.
+func NewSlogHandler(level slog.Leveler) *SlogHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &SlogHandler{level: level}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return Writer != nil && level >= h.level.Level() && levels[slogLevel(level)] >= atomic.LoadInt32(&minLevel)
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	e := newEvent(r.Message, t)
+	e.Level = slogLevel(r.Level)
+	if ctx != nil {
+		FromContext(ctx)(&e)
+	}
+	for _, a := range h.attrs {
+		setAttr(&e, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		setAttr(&e, h.prefix, a)
+		return true
+	})
+	write(&e)
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	h2.attrs = append(h2.attrs, h.attrs...)
+	for _, a := range attrs {
+		// Prefix of current group is applied now, as later groups must not affect these attributes.
+		a.Key = h.prefix + a.Key
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func slogLevel(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "error"
+	case l >= slog.LevelWarn:
+		return "warning"
+	case l >= slog.LevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+func setAttr(e *Event, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if a.Key == "" && v.Kind() != slog.KindGroup {
+		return
+	}
+	key := prefix + a.Key
+	switch v.Kind() {
+	case slog.KindGroup:
+		// Attributes of group with empty key are inlined.
+		if a.Key != "" {
+			prefix = key + "."
+		}
+		for _, ga := range v.Group() {
+			setAttr(e, prefix, ga)
+		}
+		return
+	case slog.KindString:
+		switch a.Key {
+		case "reference_id":
+			e.ReferenceID = v.String()
+			return
+		case "user":
+			e.User = v.String()
+			return
+		case "tenant":
+			e.Tenant = v.String()
+			return
+		}
+		e.setContext(key, v.String())
+	case slog.KindInt64:
+		e.setContext(key, v.Int64())
+	case slog.KindUint64:
+		e.setContext(key, v.Uint64())
+	case slog.KindFloat64:
+		e.setContext(key, v.Float64())
+	case slog.KindBool:
+		e.setContext(key, v.Bool())
+	case slog.KindDuration:
+		e.setContext(key, milliseconds(v.Duration()))
+	case slog.KindTime:
+		e.setContext(key, v.Time().UTC().Format(time.RFC3339Nano))
+	default:
+		if err, ok := v.Any().(error); ok {
+			Error(err)(e)
+			return
+		}
+		e.setContext(key, fmt.Sprintf("%+v", v.Any()))
+	}
+}
@@ -0,0 +1,31 @@
+package log
+
+import (
+	stdlog "log"
+	"strings"
+)
+
+// StdLogger returns logger of standard library which writes each line as event,
+// so output of http.Server (e.g. TLS handshake errors) and third-party libraries
+// ends up in the same log:
+//   server := &http.Server{ErrorLog: log.StdLogger("http.Server")}
+// Source is saved in context to tell apart where the line came from.
+func StdLogger(source string) *stdlog.Logger {
+	return stdlog.New(StdWriter{Source: source}, "", 0)
+}
+
+// StdWriter converts lines of standard library logger into events.
+// Redirect the global standard logger with it:
+//   stdlog.SetFlags(0)
+//   stdlog.SetOutput(log.StdWriter{Source: "log"})
+// Error field is not set: there is no way to tell if line is an error,
+// and notifiers must not be triggered by every line of third-party library.
+type StdWriter struct {
+	Source string
+}
+
+func (w StdWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	Log(line, Context(map[string]string{"source": w.Source}))
+	return len(p), nil
+}
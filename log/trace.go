@@ -0,0 +1,21 @@
+package log
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace sets trace and span ids of OpenTelemetry span carried by ctx,
+// so events can be joined with distributed traces.
+// Nothing is set if ctx has no valid span.
+func Trace(ctx context.Context) SetFieldValue {
+	return func(e *Event) {
+		sc := trace.SpanContextFromContext(ctx)
+		if !sc.IsValid() {
+			return
+		}
+		e.TraceID = sc.TraceID().String()
+		e.SpanID = sc.SpanID().String()
+	}
+}
@@ -5,50 +5,41 @@ import (
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"io/ioutil"
+	"lib/health"
 	"lib/httpclient"
+	"lib/metrics"
 	"lib/middleware"
+	"lib/problem"
+	"lib/server"
 	"net/http"
-	"time"
+	"os"
 )
 
-func post(w http.ResponseWriter, r *http.Request) {
+func post(w http.ResponseWriter, r *http.Request) error {
 	params := httprouter.ParamsFromContext(r.Context())
 	name := params.ByName("name")
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(`{"error": "internal"}`))
-		return
+		return err
 	}
 	var req map[string]interface{}
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(`{"error": "internal"}`))
-		return
+		return fmt.Errorf("%w: invalid JSON: %v", problem.ErrBadRequest, err)
 	}
 
-	request, err := http.NewRequest("GET", "http://example.org", nil)
+	var person map[string]interface{}
+	_, err = httpclient.GetJSON(r.Context(), "http://example.org/person/{name}", &person,
+		httpclient.PathParam("name", name),
+		httpclient.Query("fields", "name,email"),
+	)
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(`{"error": "internal"}`))
-		return
-	}
-	resp, err := httpclient.Send(request, 5*time.Second, "")
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(`{"error": "internal"}`))
-		return
-	}
-	_, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(`{"error": "internal"}`))
-		return
+		return err
 	}
 
 	w.WriteHeader(201)
 	w.Write([]byte(fmt.Sprintf(`{"result": "%s"}`, name)))
+	return nil
 }
 
 func get(w http.ResponseWriter, r *http.Request) {
@@ -71,10 +62,22 @@ func check(err error) {
 }
 
 func main() {
-	reqRespLog := middleware.RequestResponseLogger
+	reqRespLog := func(route string) func(http.HandlerFunc) http.HandlerFunc {
+		return middleware.RequestResponseLoggerWith(middleware.WithRoute(route))
+	}
 	router := httprouter.New()
-	router.HandlerFunc("POST", "/person/:name", reqRespLog(post))
-	router.HandlerFunc("GET", "/api/v1", reqRespLog(get))
-	router.HandlerFunc("GET", "/api/v1/silent", reqRespLog(getSilent))
-	http.ListenAndServe(":8080", router)
+	router.HandlerFunc("POST", "/person/:name", reqRespLog("/person/:name")(problem.HandlerFunc(post).ServeHTTP))
+	router.HandlerFunc("GET", "/api/v1", reqRespLog("/api/v1")(get))
+	router.HandlerFunc("GET", "/api/v1/silent", reqRespLog("/api/v1/silent")(getSilent))
+	router.Handler("GET", "/metrics", metrics.Handler())
+	router.Handler("GET", "/healthz", health.Liveness())
+	router.Handler("GET", "/readyz", health.Readiness())
+	global := middleware.Use(
+		middleware.Wrap(middleware.TraceContext),
+		middleware.Wrap(middleware.ReferenceID),
+		middleware.Wrap(middleware.Recover),
+	)
+	if err := server.ListenAndServe(":8080", global.Then(router)); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,69 @@
+// Package metrics holds Prometheus registry shared by server middleware and httpclient,
+// so operators get dashboards of latency and errors without parsing JSON logs.
+// Expose it in router:
+//   router.Handler("GET", "/metrics", metrics.Handler())
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Registry is used instead of prometheus.DefaultRegisterer to have full control of exposed metrics.
+// Register metrics of project in it.
+var Registry = prometheus.NewRegistry()
+
+var (
+	ServerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_server_requests_total",
+		Help: "Number of handled HTTP requests.",
+	}, []string{"route", "method", "status"})
+
+	ServerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_server_request_duration_seconds",
+		Help:    "Duration of handling of HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	ServerInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_server_requests_in_flight",
+		Help: "Number of HTTP requests being handled.",
+	}, []string{"route"})
+
+	// Status of failed request is class of its error: timeout, connection_refused, dns, tls, canceled or error.
+	ClientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_requests_total",
+		Help: "Number of sent HTTP requests, each attempt is counted.",
+	}, []string{"host", "method", "status"})
+
+	ClientDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_client_request_duration_seconds",
+		Help:    "Duration of sent HTTP requests including reading of response body.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"host", "method", "status"})
+
+	ClientRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_retries_total",
+		Help: "Number of retries of sent HTTP requests.",
+	}, []string{"host", "method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		ServerRequests,
+		ServerDuration,
+		ServerInFlight,
+		ClientRequests,
+		ClientDuration,
+		ClientRetries,
+	)
+}
+
+// Handler exposes metrics of Registry in Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
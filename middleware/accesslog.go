@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"lib/log"
+	"net/http"
+	"time"
+)
+
+// AccessLogger logs transaction without headers and bodies: method, path, status, latency, user and sizes.
+// Unlike RequestResponseLogger it doesn't buffer anything, so it's cheap enough to wrap the whole router,
+// e.g. to log all GET requests. Don't wrap handler with both of them, transaction would be logged twice.
+func AccessLogger(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := &accessInfo{user: GetUser(r), tenant: GetTenant(r)}
+		r = r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info))
+		rw := newResponseWriter(w, 0)
+		start := time.Now()
+		handler.ServeHTTP(rw, r)
+		latency := time.Since(start)
+
+		log.Log(
+			fmt.Sprintf("in '%s %s' %d", r.Method, r.Host+r.URL.Path, rw.statusCode()),
+			log.ReferenceID(GetReferenceID(r)),
+			log.Trace(r.Context()),
+			log.User(info.user),
+			log.Tenant(info.tenant),
+			log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+			log.ClientIP(GetClientIP(r)),
+			log.PartialResponse(rw.statusCode(), nil, nil, rw.size),
+			log.Latency(latency),
+		)
+	}
+}
+
+// AccessLogger wraps router, while authentication and Tenant middlewares wrap routes: they save
+// user and tenant in derived request, which AccessLogger doesn't see. So they are reported to accessInfo
+// shared through context (see SetUser, SetTenant), it's read after handler returns, same as status and size.
+type accessInfo struct {
+	user   string
+	tenant string
+}
+
+type accessInfoKey struct{}
+
+func reportUser(ctx context.Context, user string) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.user = user
+	}
+}
+
+func reportTenant(ctx context.Context, tenant string) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.tenant = tenant
+	}
+}
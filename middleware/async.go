@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Serialization and writing of transaction event with bodies takes noticeable time.
+// If Async is true, RequestResponseLogger emits transaction events in background goroutine,
+// so logging never adds to latency of request.
+// Queue of events is bounded by AsyncQueueSize, when it's full the oldest event is dropped
+// (see AsyncDropped). Call Flush on shutdown before log.Close, so queued events are not lost.
+// Customize Async and AsyncQueueSize for project in init function:
+//   middleware.Async = true
+var Async = false
+
+// AsyncQueueSize is read once, when the first event is emitted asynchronously.
+var AsyncQueueSize = 1024
+
+var (
+	asyncOnce    sync.Once
+	asyncQueue   chan func()
+	asyncPending pending
+	asyncDropped uint64
+)
+
+// AsyncDropped returns number of transaction events dropped because queue was full.
+func AsyncDropped() uint64 {
+	return atomic.LoadUint64(&asyncDropped)
+}
+
+// Flush waits for queued transaction events to be written.
+func Flush(ctx context.Context) error {
+	return asyncPending.wait(ctx)
+}
+
+func emit(logEvent func()) {
+	if !Async {
+		logEvent()
+		return
+	}
+	asyncOnce.Do(func() {
+		asyncQueue = make(chan func(), AsyncQueueSize)
+		go func() {
+			for logEvent := range asyncQueue {
+				logEvent()
+				asyncPending.add(-1)
+			}
+		}()
+	})
+	asyncPending.add(1)
+	for {
+		select {
+		case asyncQueue <- logEvent:
+			return
+		default:
+		}
+		// Queue is full: drop the oldest event to make room for the new one.
+		select {
+		case <-asyncQueue:
+			atomic.AddUint64(&asyncDropped, 1)
+			asyncPending.add(-1)
+		default:
+		}
+	}
+}
+
+// pending counts queued transaction events. Unlike sync.WaitGroup, it can be incremented
+// while Flush waits for it: requests are still served during shutdown.
+type pending struct {
+	mu   sync.Mutex
+	n    int
+	zero chan struct{}
+}
+
+func (p *pending) add(delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n += delta
+	if p.n == 0 && p.zero != nil {
+		close(p.zero)
+		p.zero = nil
+	}
+}
+
+// wait waits until counter is zero.
+func (p *pending) wait(ctx context.Context) error {
+	p.mu.Lock()
+	if p.n == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.zero == nil {
+		p.zero = make(chan struct{})
+	}
+	zero := p.zero
+	p.mu.Unlock()
+	select {
+	case <-zero:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"lib/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditEvent records who did what to which resource and with what outcome.
+// Unlike transaction event of RequestResponseLogger it has fixed shape, no bodies or headers,
+// and it's not sampled or filtered by level: it's a record for compliance, not for debugging.
+type AuditEvent struct {
+	Type        string `json:"type"`
+	Timestamp   string `json:"timestamp"`
+	ReferenceID string `json:"reference_id,omitempty"`
+	TraceID     string `json:"trace_id,omitempty"`
+	Actor       string `json:"actor"`
+	ClientIP    string `json:"client_ip,omitempty"`
+	Action      string `json:"action"`
+	Resource    string `json:"resource,omitempty"`
+	// Outcome is "success", "denied" (401, 403) or "failure".
+	Outcome    string `json:"outcome"`
+	StatusCode int    `json:"status_code"`
+	// Summaries of resource before and after change, supplied by handler (see AuditChange).
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// AuditWriter receives audit events as JSON, e.g. append-only storage of project.
+// By default (nil) they are written to log.Writer among other events, tell them apart by "type": "audit".
+// Customize AuditWriter for project in init function, it must be concurrently safe.
+var AuditWriter io.Writer
+
+// Audit emits audit event of action for each request of mutating route:
+//   router.Handler("DELETE", "/article/:id", middleware.Audit("article.delete")(deleteArticle))
+// Actor is user of request, put Audit after authentication middleware.
+// Handler describes resource and its change by AuditResource and AuditChange.
+func Audit(action string) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			record := &auditRecord{}
+			r = r.WithContext(context.WithValue(r.Context(), auditKey{}, record))
+			rw := newResponseWriter(w, 0)
+			// Action failed by panic is audited too, panic is passed on to Recover.
+			defer func() {
+				v := recover()
+				status := rw.statusCode()
+				if v != nil {
+					status = http.StatusInternalServerError
+				}
+				emitAudit(r, action, status, record)
+				if v != nil {
+					panic(v)
+				}
+			}()
+			handler.ServeHTTP(rw, r)
+		}
+	}
+}
+
+func emitAudit(r *http.Request, action string, status int, record *auditRecord) {
+	record.mu.Lock()
+	e := AuditEvent{
+		Type:        "audit",
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		ReferenceID: GetReferenceID(r),
+		TraceID:     traceID(r.Context()),
+		Actor:       GetUser(r),
+		ClientIP:    GetClientIP(r),
+		Action:      action,
+		Resource:    record.resource,
+		Outcome:     outcome(status),
+		StatusCode:  status,
+		Before:      record.before,
+		After:       record.after,
+	}
+	record.mu.Unlock()
+	writeAudit(r, &e)
+}
+
+type auditKey struct{}
+
+type auditRecord struct {
+	mu                      sync.Mutex
+	resource, before, after string
+}
+
+// AuditResource sets resource of audit event, e.g. "article/42".
+// It has no effect if request is not wrapped by Audit.
+func AuditResource(r *http.Request, resource string) {
+	if record, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		record.mu.Lock()
+		record.resource = resource
+		record.mu.Unlock()
+	}
+}
+
+// AuditChange sets summaries of resource before and after change.
+// Summaries are stored for long, don't put secrets and personal data in them.
+// It has no effect if request is not wrapped by Audit.
+func AuditChange(r *http.Request, before, after string) {
+	if record, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		record.mu.Lock()
+		record.before, record.after = before, after
+		record.mu.Unlock()
+	}
+}
+
+func outcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	case status >= 400:
+		return "failure"
+	default:
+		return "success"
+	}
+}
+
+func traceID(ctx context.Context) string {
+	var e log.Event
+	log.Trace(ctx)(&e)
+	return e.TraceID
+}
+
+// Lost audit event is an error: people on duty must be notified.
+// If AuditWriter isn't set and logging is disabled (log.Writer is nil), nothing is written.
+func writeAudit(r *http.Request, e *AuditEvent) {
+	w := AuditWriter
+	if w == nil {
+		w = log.Writer
+	}
+	if w == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	if err != nil {
+		log.Log(
+			"failed AuditWriter.Write",
+			log.FromContext(r.Context()),
+			log.Error(err),
+			log.Context(map[string]string{"action": e.Action, "resource": e.Resource, "outcome": e.Outcome}),
+		)
+	}
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"lib/log"
+	"lib/problem"
+	"net/http"
+	"strings"
+)
+
+type JWTConfig struct {
+	// Key to verify signature: []byte for HMAC, *rsa.PublicKey for RSA, *ecdsa.PublicKey for ECDSA.
+	Key interface{}
+	// Optional, it's used instead of Key to select key by token header, e.g. by "kid" for key rotation.
+	Keyfunc jwt.Keyfunc
+	// Allowed signing algorithms, HS256 by default.
+	// It must be set explicitly, otherwise token signed by another algorithm might be accepted.
+	Methods []string
+	// Expected "iss" and "aud" claims, not checked if empty.
+	Issuer   string
+	Audience string
+}
+
+// JWT authenticates request by JSON Web Token in "Authorization: Bearer <token>" header.
+// Subject ("sub" claim) of valid token is saved in request context as user,
+// so all events of request are logged with it (see GetUser).
+// Request without valid token is rejected with 401, the reason is logged.
+//   auth := middleware.JWT(middleware.JWTConfig{Key: secret, Issuer: "auth.example.org"})
+//   router.Handler("POST", "/article", auth(createArticle))
+func JWT(config JWTConfig) func(http.Handler) http.HandlerFunc {
+	keyfunc := config.Keyfunc
+	if keyfunc == nil {
+		keyfunc = func(*jwt.Token) (interface{}, error) {
+			return config.Key, nil
+		}
+	}
+	methods := config.Methods
+	if len(methods) == 0 {
+		methods = []string{"HS256"}
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+	parser := jwt.NewParser(opts...)
+
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			user, claims, err := jwtSubject(parser, keyfunc, r)
+			if err != nil {
+				rejectUnauthorized(w, r, "Bearer", err)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, claims))
+			handler.ServeHTTP(w, SetUser(r, user))
+		}
+	}
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaims returns claims of token validated by JWT, nil if request isn't wrapped by it.
+func JWTClaims(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(jwtClaimsKey{}).(jwt.MapClaims)
+	return claims
+}
+
+func jwtSubject(parser *jwt.Parser, keyfunc jwt.Keyfunc, r *http.Request) (string, jwt.MapClaims, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", nil, errors.New("no bearer token")
+	}
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), claims, keyfunc)
+	if err != nil {
+		return "", nil, err
+	}
+	sub, err := token.Claims.GetSubject()
+	if err != nil {
+		return "", nil, err
+	}
+	if sub == "" {
+		return "", nil, errors.New("no sub claim")
+	}
+	return sub, claims, nil
+}
+
+// Reason of rejection is logged, but not sent to client.
+// It's not logged as Error: invalid credentials is a mistake of client, people on duty
+// must not be notified about it.
+func rejectUnauthorized(w http.ResponseWriter, r *http.Request, scheme string, err error) {
+	refID := GetReferenceID(r)
+	log.Log(
+		"failed authentication",
+		log.ReferenceID(refID),
+		log.Trace(r.Context()),
+		log.Tenant(GetTenant(r)),
+		log.Context(map[string]string{"scheme": scheme, "reason": err.Error()}),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		log.ClientIP(GetClientIP(r)),
+	)
+	w.Header().Set("WWW-Authenticate", scheme)
+	problem.Write(w, r, http.StatusUnauthorized)
+}
+
+// BasicAuth authenticates request by HTTP Basic credentials, check validates them
+// against credential store. Use subtle.ConstantTimeCompare to compare secrets in check.
+// Username of valid credentials is saved in request context as user (see GetUser).
+// Request without valid credentials is rejected with 401, the reason is logged.
+func BasicAuth(realm string, check func(username, password string) bool) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if !ok {
+				rejectUnauthorized(w, r, fmt.Sprintf("Basic realm=%q", realm), errors.New("no basic credentials"))
+				return
+			}
+			if !check(username, password) {
+				rejectUnauthorized(w, r, fmt.Sprintf("Basic realm=%q", realm), fmt.Errorf("invalid password of user %q", username))
+				return
+			}
+			handler.ServeHTTP(w, SetUser(r, username))
+		}
+	}
+}
+
+// APIKey authenticates request by key in X-API-Key header, lookup returns user (owner) of the key.
+// User is saved in request context (see GetUser).
+// Request without valid key is rejected with 401, the reason is logged.
+func APIKey(lookup func(key string) (user string, ok bool)) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-Key")
+			if key == "" {
+				rejectUnauthorized(w, r, "APIKey", errors.New("no X-API-Key header"))
+				return
+			}
+			user, ok := lookup(key)
+			if !ok {
+				// Key itself is a secret, only its prefix is logged to tell apart invalid keys.
+				rejectUnauthorized(w, r, "APIKey", fmt.Errorf("unknown key %s...", keyPrefix(key)))
+				return
+			}
+			handler.ServeHTTP(w, SetUser(r, user))
+		}
+	}
+}
+
+func keyPrefix(key string) string {
+	if len(key) > 4 {
+		return key[:4]
+	}
+	return ""
+}
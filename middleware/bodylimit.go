@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"errors"
+	"lib/log"
+	"lib/problem"
+	"net/http"
+)
+
+// MaxBodySize limits size of request body, so huge payloads don't exhaust memory
+// of handlers which read body as a whole.
+// Request with Content-Length over limit is rejected with 413 right away.
+// Otherwise reading of body fails after limit bytes with *http.MaxBytesError,
+// handlers should respond with 413 then (see IsBodyTooLarge).
+func MaxBodySize(limit int64) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				rejectBodyTooLarge(w, r, limit)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// Rejection is client's mistake, so it's not logged as Error.
+func rejectBodyTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	log.Log(
+		"rejected request body",
+		log.FromContext(r.Context()),
+		log.Context(map[string]string{"reason": "body is too large"}),
+		log.Int("limit", int(limit)),
+		log.Int("content_length", int(r.ContentLength)),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		log.ClientIP(GetClientIP(r)),
+	)
+	problem.Write(w, r, http.StatusRequestEntityTooLarge)
+}
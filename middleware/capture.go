@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// requestBody passes request body through to handler and captures its first limit bytes for logging,
+// so large uploads flow through RequestResponseLogger without being buffered in memory.
+// Only what handler reads is captured: body is not read after handler returns,
+// net/http may have closed it by then.
+type requestBody struct {
+	io.ReadCloser
+
+	// The first limit bytes of body.
+	captured bytes.Buffer
+	limit    int
+	// Total bytes read from body.
+	size int
+	eof  bool
+	// The first error of reading except io.EOF, e.g. *http.MaxBytesError.
+	err error
+}
+
+func newRequestBody(body io.ReadCloser, limit int) *requestBody {
+	return &requestBody{ReadCloser: body, limit: limit}
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *requestBody) capture(p []byte) {
+	b.size += len(p)
+	if free := b.limit - b.captured.Len(); free > 0 {
+		if free > len(p) {
+			free = len(p)
+		}
+		b.captured.Write(p[:free])
+	}
+}
+
+// totalSize is size of body if it's read to the end, otherwise Content-Length if it's known.
+func (b *requestBody) totalSize(contentLength int64) int {
+	if b.eof || contentLength < int64(b.size) {
+		return b.size
+	}
+	return int(contentLength)
+}
+
+// bufferedBody is request body read into memory by middleware which needs it as a whole before
+// handler runs, e.g. to verify signature (see HMAC). Other such middlewares reuse it instead
+// of buffering body again, handler reads it as usual.
+type bufferedBody struct {
+	*bytes.Reader
+	data []byte
+}
+
+func (b *bufferedBody) Close() error {
+	return nil
+}
+
+// bufferBody returns the whole body of request. Body is read into memory only if it isn't buffered
+// by another middleware already, then r.Body is replaced by the buffer.
+func bufferBody(r *http.Request) ([]byte, error) {
+	if b, ok := r.Body.(*bufferedBody); ok {
+		return b.data, nil
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = &bufferedBody{Reader: bytes.NewReader(data), data: data}
+	return data, nil
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import "net/http"
+
+// Middleware wraps handler to run code before and/or after it.
+type Middleware func(http.Handler) http.Handler
+
+// Wrap adapts middleware returning http.HandlerFunc (ReferenceID, Recover, TraceContext) to Middleware.
+func Wrap(m func(http.Handler) http.HandlerFunc) Middleware {
+	return func(h http.Handler) http.Handler {
+		return m(h)
+	}
+}
+
+// WrapFunc adapts middleware of handler func (RequestResponseLogger) to Middleware.
+func WrapFunc(m func(http.HandlerFunc) http.HandlerFunc) Middleware {
+	return func(h http.Handler) http.Handler {
+		return m(h.ServeHTTP)
+	}
+}
+
+// Chain wraps handler with middlewares in declared order: the first one is the outermost,
+// it's the first to receive request.
+//   Chain(router, Wrap(ReferenceID), Wrap(Recover))
+// is the same as
+//   ReferenceID(Recover(router))
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Stack is a reusable list of middlewares, for example global ones for router
+// and extended ones for particular routes:
+//   global := middleware.Use(middleware.Wrap(middleware.ReferenceID), middleware.Wrap(middleware.Recover))
+//   logged := global.Use(middleware.WrapFunc(middleware.RequestResponseLogger))
+//   router.Handler("POST", "/article", logged.ThenFunc(createArticle))
+type Stack []Middleware
+
+func Use(middlewares ...Middleware) Stack {
+	return Stack(nil).Use(middlewares...)
+}
+
+// Use returns new stack with middlewares appended, original stack is not modified.
+func (s Stack) Use(middlewares ...Middleware) Stack {
+	stack := make(Stack, 0, len(s)+len(middlewares))
+	stack = append(stack, s...)
+	return append(stack, middlewares...)
+}
+
+func (s Stack) Then(h http.Handler) http.Handler {
+	return Chain(h, s...)
+}
+
+func (s Stack) ThenFunc(h http.HandlerFunc) http.Handler {
+	return Chain(h, s...)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Behind reverse proxies RemoteAddr of request is address of the nearest proxy.
+// Proxies add address of their client to Forwarded (RFC 7239) or X-Forwarded-For header,
+// but client can send these headers too, so only last TrustedProxies hops are trusted.
+// The first of untrusted hops is address of client.
+// By default there are no trusted proxies and RemoteAddr is used.
+// Customize TrustedProxies for project in init function, e.g. for service behind load balancer:
+//   middleware.TrustedProxies = 1
+var TrustedProxies = 0
+
+// GetClientIP returns IP address of client of request, see TrustedProxies.
+// X-Real-IP header is used if proxies are trusted but there is no Forwarded/X-Forwarded-For header.
+func GetClientIP(r *http.Request) string {
+	remote := hostIP(r.RemoteAddr)
+	if TrustedProxies <= 0 {
+		return remote
+	}
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		if ip := r.Header.Get("X-Real-IP"); net.ParseIP(ip) != nil {
+			return ip
+		}
+		return remote
+	}
+	hops = append(hops, remote)
+	i := len(hops) - 1 - TrustedProxies
+	if i < 0 {
+		i = 0
+	}
+	if net.ParseIP(hops[i]) == nil {
+		// Proxy might hide address ("unknown" or obfuscated identifier of RFC 7239).
+		return remote
+	}
+	return hops[i]
+}
+
+// forwardedFor returns addresses of hops from Forwarded header if it's present,
+// otherwise from X-Forwarded-For header. Client is the first one.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, hostIP(strings.Trim(v, `"`)))
+				}
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, hostIP(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// hostIP strips port and brackets of IPv6 address: "[::1]:80" -> "::1".
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
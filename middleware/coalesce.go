@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"lib/log"
+	"net/http"
+	"sync"
+)
+
+// CoalesceMaxBody limits size of response shared by coalesced requests,
+// waiters of bigger response run handler themselves.
+var CoalesceMaxBody = 1 << 20
+
+type flight struct {
+	done    chan struct{}
+	waiters int
+	// Response is nil if it can't be shared: client of leader disconnected, handler wrote nothing,
+	// failed (5xx), streamed, hijacked or wrote too much.
+	resp *StoredResponse
+}
+
+// Coalesce runs handler once for concurrent identical GET requests (the same path, query, user and tenant),
+// other requests wait for its response and get a copy of it, e.g. to protect database
+// from thundering herd on cache miss. Number of coalesced requests is logged.
+// Failed (5xx) and empty responses, and responses to disconnected clients are not shared.
+// Other headers are not compared: use Coalesce only for handlers whose response depends on URL and user only.
+// Waiters get their own Reference-ID header and "Coalesced: true" header,
+// reference id of request which ran handler is logged as coalesced_with.
+func Coalesce(handler http.Handler) http.HandlerFunc {
+	var mu sync.Mutex
+	flights := make(map[string]*flight)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		key := GetUser(r) + " " + GetTenant(r) + " " + r.URL.RequestURI()
+
+		mu.Lock()
+		if f, ok := flights[key]; ok {
+			f.waiters++
+			mu.Unlock()
+			select {
+			case <-f.done:
+			case <-r.Context().Done():
+				return
+			}
+			if f.resp == nil {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			log.AddFields(r.Context(), log.Context(map[string]string{"coalesced_with": f.resp.ReferenceID}))
+			writeCoalesced(w, f.resp)
+			return
+		}
+		f := &flight{done: make(chan struct{})}
+		flights[key] = f
+		mu.Unlock()
+
+		rw := newResponseWriter(w, CoalesceMaxBody)
+		// Waiters must be released even if handler panics.
+		defer func() {
+			mu.Lock()
+			delete(flights, key)
+			waiters := f.waiters
+			mu.Unlock()
+			close(f.done)
+			if waiters > 0 {
+				log.Log(
+					"coalesced requests",
+					log.FromContext(r.Context()),
+					log.Int("coalesced", waiters),
+					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+				)
+			}
+		}()
+		handler.ServeHTTP(rw, r)
+		// Handler of disconnected client writes nothing (see problem.HandlerFunc), waiters run handler themselves.
+		if r.Context().Err() != nil || rw.status == 0 || rw.statusCode() >= 500 {
+			return
+		}
+		if rw.size > CoalesceMaxBody || rw.flushed || rw.hijacked || rw.err != nil {
+			return
+		}
+		f.resp = &StoredResponse{
+			ReferenceID: GetReferenceID(r),
+			StatusCode:  rw.statusCode(),
+			Header:      w.Header().Clone(),
+			Body:        rw.body.Bytes(),
+		}
+	}
+}
+
+func writeCoalesced(w http.ResponseWriter, resp *StoredResponse) {
+	refID := w.Header().Get("Reference-ID")
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	if refID != "" {
+		w.Header().Set("Reference-ID", refID)
+	}
+	w.Header().Set("Coalesced", "true")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
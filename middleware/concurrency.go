@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"lib/log"
+	"lib/problem"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyLimit caps number of requests handled at the same time, so overload doesn't blow up
+// memory of handlers which buffer bodies.
+// Requests over limit wait in queue of queue requests for maxWait at most.
+// Requests which don't fit in queue or wait for too long are shed with 503 and Retry-After header, and logged.
+// Each call creates its own limiter: wrap router to limit all requests or particular routes to limit them separately.
+func ConcurrencyLimit(limit, queue int, maxWait time.Duration) func(http.Handler) http.HandlerFunc {
+	slots := make(chan struct{}, limit)
+	var waiting int64
+	retryAfter := int(math.Ceil(maxWait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			serve := func() {
+				defer func() { <-slots }()
+				handler.ServeHTTP(w, r)
+			}
+			select {
+			case slots <- struct{}{}:
+				serve()
+				return
+			default:
+			}
+
+			if atomic.AddInt64(&waiting, 1) > int64(queue) {
+				atomic.AddInt64(&waiting, -1)
+				shed(w, r, "queue is full", limit, queue, retryAfter)
+				return
+			}
+			timer := time.NewTimer(maxWait)
+			defer timer.Stop()
+			select {
+			case slots <- struct{}{}:
+				atomic.AddInt64(&waiting, -1)
+				serve()
+			case <-timer.C:
+				atomic.AddInt64(&waiting, -1)
+				shed(w, r, "queue timeout", limit, queue, retryAfter)
+			case <-r.Context().Done():
+				atomic.AddInt64(&waiting, -1)
+				shed(w, r, "client is gone", limit, queue, retryAfter)
+			}
+		}
+	}
+}
+
+// Shedding is not a failure of service, so it's not logged as Error.
+func shed(w http.ResponseWriter, r *http.Request, reason string, limit, queue, retryAfter int) {
+	log.Log(
+		"shed request",
+		log.FromContext(r.Context()),
+		log.Context(map[string]string{"reason": reason}),
+		log.Int("limit", limit),
+		log.Int("queue", queue),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		log.ClientIP(GetClientIP(r)),
+	)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	problem.Write(w, r, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"lib/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Deadline sets deadline of request context by timeout requested by client in X-Request-Timeout header
+// (Go duration: "1.5s", "300ms") or Grpc-Timeout header ("300m", see gRPC over HTTP2 spec), capped by max.
+// Request without valid header gets max, zero max doesn't cap timeout.
+// Handler's database queries and outgoing requests made with context of request fail when time is over,
+// httpclient.Send passes remaining time downstream in X-Request-Timeout header.
+// Request which exceeded deadline is logged as warning with its timeout.
+func Deadline(max time.Duration) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			timeout, ok := requestTimeout(r.Header)
+			if !ok || (max > 0 && timeout > max) {
+				timeout = max
+			}
+			if timeout <= 0 {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			start := time.Now()
+			handler.ServeHTTP(w, r.WithContext(ctx))
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				log.AddFields(ctx, log.Bool("deadline_exceeded", true))
+				log.Log(
+					"exceeded request deadline",
+					log.Warning(),
+					log.FromContext(ctx),
+					log.Duration("timeout_ms", timeout),
+					log.Duration("elapsed_ms", time.Since(start)),
+					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+				)
+			}
+		}
+	}
+}
+
+func requestTimeout(h http.Header) (time.Duration, bool) {
+	if v := h.Get("X-Request-Timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		return d, err == nil && d > 0
+	}
+	if v := h.Get("Grpc-Timeout"); len(v) > 1 {
+		units := map[byte]time.Duration{
+			'H': time.Hour,
+			'M': time.Minute,
+			'S': time.Second,
+			'm': time.Millisecond,
+			'u': time.Microsecond,
+			'n': time.Nanosecond,
+		}
+		unit, ok := units[v[len(v)-1]]
+		// Value is at most 8 digits.
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if !ok || err != nil || n <= 0 || len(v) > 9 {
+			return 0, false
+		}
+		return time.Duration(n) * unit, true
+	}
+	return 0, false
+}
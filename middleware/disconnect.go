@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// disconnectWatch records when client closed connection: net/http cancels context
+// of request then, so database queries and outgoing requests of handler fail with context.Canceled.
+type disconnectWatch struct {
+	stop func() bool
+	done chan struct{}
+	at   time.Time
+}
+
+func watchDisconnect(ctx context.Context) *disconnectWatch {
+	d := &disconnectWatch{done: make(chan struct{})}
+	d.stop = context.AfterFunc(ctx, func() {
+		// Deadline set by outer middleware is not a disconnect.
+		if errors.Is(ctx.Err(), context.Canceled) {
+			d.at = time.Now()
+		}
+		close(d.done)
+	})
+	return d
+}
+
+// disconnected must be called after handler returns and before ServeHTTP does:
+// net/http cancels context of request when ServeHTTP returns too.
+func (d *disconnectWatch) disconnected() (time.Time, bool) {
+	if d.stop() {
+		return time.Time{}, false
+	}
+	<-d.done
+	return d.at, !d.at.IsZero()
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// Bodies of successful transactions are rarely needed but make most of log volume of chatty services.
+// If BodiesOnError is true, RequestResponseLogger logs bodies of request and response
+// only if status is 4xx/5xx or handler flagged transaction by KeepBodies.
+// Other transactions are logged with metadata only: headers, sizes of bodies, latency.
+// Customize BodiesOnError for project in init function:
+//   middleware.BodiesOnError = true
+var BodiesOnError = false
+
+type keepBodiesKey struct{}
+
+// KeepBodies flags transaction of request, so its bodies are logged even if BodiesOnError is set.
+// It has no effect if request is not wrapped by RequestResponseLogger.
+func KeepBodies(r *http.Request) {
+	if keep, ok := r.Context().Value(keepBodiesKey{}).(*bool); ok {
+		*keep = true
+	}
+}
+
+func withKeepBodies(r *http.Request) (*http.Request, *bool) {
+	keep := new(bool)
+	return r.WithContext(context.WithValue(r.Context(), keepBodiesKey{}, keep)), keep
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"lib/log"
+	"lib/problem"
+	"net/http"
+	"strings"
+)
+
+// GunzipRequest transparently decompresses request body with "Content-Encoding: gzip" (in any case),
+// so handlers and RequestResponseLogger get plain body. Headers are changed in copy of request.
+// Compressed and decompressed sizes are added to context of transaction event
+// (compressed_bytes, decompressed_bytes), put GunzipRequest before RequestResponseLogger.
+// Combine it with MaxBodySize placed after it: limit of compressed body doesn't protect from gzip bombs.
+func GunzipRequest(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.TrimSpace(r.Header.Get("Content-Encoding"))
+		if !strings.EqualFold(encoding, "gzip") || r.Body == nil || r.Body == http.NoBody {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		compressed := &countingReader{r: r.Body}
+		zr, err := gzip.NewReader(compressed)
+		if err != nil {
+			log.Log(
+				"rejected request body",
+				log.FromContext(r.Context()),
+				log.Context(map[string]string{"reason": "invalid gzip", "error": err.Error()}),
+				log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, nil),
+				log.ClientIP(GetClientIP(r)),
+			)
+			problem.Write(w, r, http.StatusBadRequest)
+			return
+		}
+		decompressed := &countingReader{r: zr}
+
+		ctx := log.WithFields(r.Context())
+		log.AddFields(ctx, func(e *log.Event) {
+			log.Int("compressed_bytes", compressed.n)(e)
+			log.Int("decompressed_bytes", decompressed.n)(e)
+		})
+		r = r.Clone(ctx)
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{decompressed, r.Body}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		handler.ServeHTTP(w, r)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
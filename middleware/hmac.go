@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"lib/log"
+	"lib/problem"
+	"net/http"
+	"strings"
+)
+
+// HMACConfig configures verification of signed requests, e.g. webhooks of GitHub:
+//   middleware.HMACConfig{Header: "X-Hub-Signature-256", Prefix: "sha256=", Secrets: [][]byte{secret}}
+type HMACConfig struct {
+	// Header with signature of body.
+	Header string
+	// Prefix of signature in header, e.g. "sha256=".
+	Prefix string
+	// Hash is sha256.New by default.
+	Hash func() hash.Hash
+	// Signature is encoded in hex by default.
+	Base64 bool
+	// Secrets are valid secrets of sender. Signature made with any of them is accepted,
+	// so secret is rotated by adding new one, switching sender to it and removing old one.
+	Secrets [][]byte
+}
+
+// HMAC verifies signature of request body before handler runs.
+// Body is read as a whole (unless another middleware has buffered it already), put MaxBodySize before HMAC.
+// Handler gets body as is.
+// Request with invalid signature is rejected with 401, prefixes of provided and computed (with the first secret)
+// signatures are logged, so mismatched secret or encoding can be told apart from forgery.
+func HMAC(config HMACConfig) func(http.Handler) http.HandlerFunc {
+	if config.Hash == nil {
+		config.Hash = sha256.New
+	}
+	if len(config.Secrets) == 0 {
+		panic("middleware: HMAC requires at least one secret")
+	}
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = bufferBody(r)
+				if IsBodyTooLarge(err) {
+					var maxBytesErr *http.MaxBytesError
+					errors.As(err, &maxBytesErr)
+					rejectBodyTooLarge(w, r, maxBytesErr.Limit)
+					return
+				}
+				if err != nil {
+					log.Log(
+						"failed ioutil.ReadAll",
+						log.FromContext(r.Context()),
+						log.Error(err),
+						log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+					)
+					problem.Write(w, r, http.StatusBadRequest)
+					return
+				}
+			}
+
+			provided := r.Header.Get(config.Header)
+			if !strings.HasPrefix(provided, config.Prefix) {
+				rejectSignature(w, r, "no signature", provided, "")
+				return
+			}
+			signature, err := config.decode(strings.TrimPrefix(provided, config.Prefix))
+			if err != nil {
+				rejectSignature(w, r, "invalid encoding of signature", provided, "")
+				return
+			}
+			for _, secret := range config.Secrets {
+				if hmac.Equal(signature, config.sign(secret, body)) {
+					handler.ServeHTTP(w, r)
+					return
+				}
+			}
+			rejectSignature(w, r, "signature mismatch", provided, config.Prefix+config.encode(config.sign(config.Secrets[0], body)))
+		}
+	}
+}
+
+func (c *HMACConfig) sign(secret, body []byte) []byte {
+	mac := hmac.New(c.Hash, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (c *HMACConfig) encode(signature []byte) string {
+	if c.Base64 {
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	return hex.EncodeToString(signature)
+}
+
+func (c *HMACConfig) decode(signature string) ([]byte, error) {
+	if c.Base64 {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+	return hex.DecodeString(signature)
+}
+
+// Only prefixes of signatures are logged: the full computed signature would let
+// anyone with access to log forge request with the same body.
+// Rejection is not logged as Error: anyone can send request without valid signature.
+func rejectSignature(w http.ResponseWriter, r *http.Request, reason, provided, computed string) {
+	log.Log(
+		"failed signature verification",
+		log.FromContext(r.Context()),
+		log.Context(map[string]string{
+			"reason":             reason,
+			"provided_signature": signaturePrefix(provided),
+			"computed_signature": signaturePrefix(computed),
+		}),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		log.ClientIP(GetClientIP(r)),
+	)
+	problem.Write(w, r, http.StatusUnauthorized)
+}
+
+func signaturePrefix(signature string) string {
+	const n = 16
+	if len(signature) > n {
+		return signature[:n] + "..."
+	}
+	return signature
+}
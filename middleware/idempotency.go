@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"lib/log"
+	"lib/problem"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StoredResponse is response to request with Idempotency-Key, which is replayed for its retries.
+type StoredResponse struct {
+	// Reference id of the original request, replays are logged with it.
+	ReferenceID string
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+}
+
+// IdempotencyStore keeps responses by idempotency keys. Implementation must be safe for concurrent use.
+// Shared store (Redis, database) is required if service has several instances.
+type IdempotencyStore interface {
+	Get(key string) (*StoredResponse, bool)
+	Put(key string, resp *StoredResponse)
+}
+
+// IdempotencyMaxBody limits size of stored response body, bigger responses are not stored.
+var IdempotencyMaxBody = 1 << 20
+
+// Idempotency makes retries of unsafe requests (POST payments, orders) safe for clients:
+// response to request with Idempotency-Key header is stored and replayed for requests with the same key,
+// handler is not called again. Keys are scoped by user, method and path, put Idempotency after authentication.
+// Request with key of request which is still in progress is rejected with 409.
+// Responses with 5xx status, streamed and too big ones are not stored, so such requests can be retried.
+// Neither are responses to disconnected clients and empty responses of handlers which wrote nothing.
+func Idempotency(store IdempotencyStore) func(http.Handler) http.HandlerFunc {
+	var mu sync.Mutex
+	inProgress := make(map[string]bool)
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get("Idempotency-Key")
+			if idempotencyKey == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			key := GetUser(r) + " " + r.Method + " " + r.URL.Path + " " + idempotencyKey
+
+			if resp, ok := store.Get(key); ok {
+				replay(w, r, resp)
+				return
+			}
+			mu.Lock()
+			if inProgress[key] {
+				mu.Unlock()
+				log.Log(
+					"rejected idempotent request",
+					log.FromContext(r.Context()),
+					log.Context(map[string]string{"reason": "request with the same key is in progress", "idempotency_key": idempotencyKey}),
+					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+					log.ClientIP(GetClientIP(r)),
+				)
+				problem.Write(w, r, http.StatusConflict)
+				return
+			}
+			inProgress[key] = true
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				delete(inProgress, key)
+				mu.Unlock()
+			}()
+			// Request with the same key may have finished between the first lookup and the claim.
+			if resp, ok := store.Get(key); ok {
+				replay(w, r, resp)
+				return
+			}
+
+			rw := newResponseWriter(w, IdempotencyMaxBody)
+			handler.ServeHTTP(rw, r)
+			// Handler of disconnected client writes nothing (see problem.HandlerFunc), its retry must run handler.
+			if rw.status == 0 || r.Context().Err() != nil {
+				return
+			}
+			if rw.statusCode() >= 500 || rw.size > IdempotencyMaxBody || rw.flushed || rw.hijacked || rw.err != nil {
+				return
+			}
+			store.Put(key, &StoredResponse{
+				ReferenceID: GetReferenceID(r),
+				StatusCode:  rw.statusCode(),
+				Header:      w.Header().Clone(),
+				Body:        rw.body.Bytes(),
+			})
+		}
+	}
+}
+
+// Replayed response keeps Reference-ID of retry, the original one is logged.
+func replay(w http.ResponseWriter, r *http.Request, resp *StoredResponse) {
+	log.Log(
+		"replayed idempotent request",
+		log.FromContext(r.Context()),
+		log.Context(map[string]string{"original_reference_id": resp.ReferenceID, "idempotency_key": r.Header.Get("Idempotency-Key")}),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		log.ClientIP(GetClientIP(r)),
+	)
+	refID := w.Header().Get("Reference-ID")
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	if refID != "" {
+		w.Header().Set("Reference-ID", refID)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// MemoryStore is IdempotencyStore in memory of process, responses expire after TTL.
+// Zero value with TTL set is ready to use, same as one made by NewMemoryStore.
+type MemoryStore struct {
+	TTL time.Duration
+
+	mu        sync.Mutex
+	responses map[string]storedResponse
+	lastSweep time.Time
+}
+
+type storedResponse struct {
+	resp    *StoredResponse
+	expires time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{TTL: ttl, responses: make(map[string]storedResponse)}
+}
+
+func (s *MemoryStore) Get(key string) (*StoredResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.responses[key]
+	if !ok || time.Now().After(stored.expires) {
+		return nil, false
+	}
+	return stored.resp, true
+}
+
+// Expired responses are removed once per TTL, otherwise map grows with every new key.
+func (s *MemoryStore) Put(key string, resp *StoredResponse) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) > s.TTL {
+		for k, stored := range s.responses {
+			if now.After(stored.expires) {
+				delete(s.responses, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if s.responses == nil {
+		s.responses = make(map[string]storedResponse)
+	}
+	s.responses[key] = storedResponse{resp: resp, expires: now.Add(s.TTL)}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"lib/log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type localeKey struct{}
+
+// Locale negotiates locale of response by Accept-Language header of request among supported locales
+// (BCP 47 tags: "en", "en-GB", "de"), the first one is default. Handler gets it by GetLocale,
+// it's added to context of transaction event as locale.
+//   router.Handler("GET", "/orders", middleware.Locale("en", "de", "fr-CA")(orders))
+// Tags are matched case-insensitively, exact match is preferred, then match by language:
+// "de-AT" gets "de", "fr" gets "fr-CA".
+func Locale(supported ...string) func(http.Handler) http.HandlerFunc {
+	if len(supported) == 0 {
+		panic("middleware: Locale requires at least one supported locale")
+	}
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			locale := negotiateLocale(r.Header.Get("Accept-Language"), supported)
+			log.AddFields(r.Context(), log.Context(map[string]string{"locale": locale}))
+			// Response depends on header, caches must know it.
+			w.Header().Add("Vary", "Accept-Language")
+			handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, locale)))
+		}
+	}
+}
+
+// GetLocale returns locale negotiated by Locale, empty string if request isn't wrapped by it.
+func GetLocale(r *http.Request) string {
+	locale, _ := r.Context().Value(localeKey{}).(string)
+	return locale
+}
+
+type weightedTag struct {
+	tag string
+	q   float64
+}
+
+func negotiateLocale(header string, supported []string) string {
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weightedTag{tag, q})
+		}
+	}
+	// Stable: tags of the same weight keep order of client.
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if t.tag == "*" {
+			return supported[0]
+		}
+		for _, s := range supported {
+			if strings.EqualFold(t.tag, s) {
+				return s
+			}
+		}
+		for _, s := range supported {
+			if strings.EqualFold(language(t.tag), language(s)) {
+				return s
+			}
+		}
+	}
+	return supported[0]
+}
+
+func language(tag string) string {
+	lang, _, _ := strings.Cut(tag, "-")
+	return lang
+}
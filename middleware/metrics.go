@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"lib/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics records count, duration and in-flight number of requests of route (see package metrics).
+// Route is a template of path ("/person/:name"), not the path itself:
+// labels of metrics must have low cardinality.
+//   router.Handler("POST", "/person/:name", middleware.Metrics("/person/:name")(post))
+func Metrics(route string) func(http.Handler) http.HandlerFunc {
+	inFlight := metrics.ServerInFlight.WithLabelValues(route)
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			rw := newResponseWriter(w, 0)
+			start := time.Now()
+			handler.ServeHTTP(rw, r)
+			status := strconv.Itoa(rw.statusCode())
+			metrics.ServerRequests.WithLabelValues(route, r.Method, status).Inc()
+			metrics.ServerDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+		}
+	}
+}
@@ -1,54 +1,226 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
 	"fmt"
-	"github.com/lithammer/shortuuid"
-	"io/ioutil"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"lib/log"
+	"lib/problem"
+	"net"
 	"net/http"
-	"net/http/httptest"
+	"runtime/debug"
+	"text/template"
+	"time"
 )
 
+// ReferenceID sets reference id of request in its context and in Reference-ID response header.
+// Reference-ID header of request is accepted only from trusted networks (see TrustedNetworks)
+// and only if it's valid, otherwise new reference id is generated.
 func ReferenceID(handler http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		// TODO do we trust our clients?
-		ref := req.Header.Get("Reference-ID")
-		if ref == "" {
-			ref = shortuuid.New()
+	return ReferenceIDWith()(handler)
+}
+
+// ReferenceIDWith returns ReferenceID tuned by options: WithHeader, WithGenerator.
+func ReferenceIDWith(opts ...Option) func(http.Handler) http.HandlerFunc {
+	o := newOptions(opts)
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			header := o.referenceIDHeader()
+			ref := req.Header.Get(header)
+			if ref == "" || !validReferenceID(ref) || !trusted(req.RemoteAddr) {
+				ref = o.newReferenceID()
+			}
+			req = SetReferenceID(req, ref)
+			w.Header().Set(header, ref)
+			handler.ServeHTTP(w, req)
 		}
-		ctx := context.WithValue(req.Context(), "reference_id", ref)
-		req = req.WithContext(ctx)
-		w.Header().Set("Reference-ID", ref)
-		handler.ServeHTTP(w, req)
 	}
 }
 
-func Recover(handler http.Handler) http.HandlerFunc {
+// Clients can't be trusted: they might spoof reference id of another request to mix up logs.
+// Reference-ID header is accepted only from TrustedNetworks: proxies, other services of project.
+// By default these are loopback and private networks.
+// Customize TrustedNetworks for project in init function, empty list trusts no one:
+//   middleware.TrustedNetworks = middleware.MustParseCIDRs("10.0.0.0/8")
+var TrustedNetworks = MustParseCIDRs("127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func MustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func trusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range TrustedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reference id is shown to users and written to logs, so it's limited to short
+// string of safe characters: letters, digits, '-', '_' and '.'.
+func validReferenceID(ref string) bool {
+	if len(ref) > 64 {
+		return false
+	}
+	for _, c := range ref {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// TraceContext extracts W3C Trace Context (traceparent and tracestate headers) of request
+// and stores it in request context, so log events of transaction get trace id (see log.Trace)
+// and httpclient.Send propagates it to downstream services.
+// If request has no valid traceparent, new trace is started.
+func TraceContext(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				refID := GetReferenceID(r)
-				user := GetUser(r)
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, newSpanContext())
+		}
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
 
-				log.Log(
-					"failed handler.ServeHTTP",
-					log.ReferenceID(refID),
-					log.User(user),
-					log.Error(fmt.Errorf("%v", err)),
-					log.Context(map[string]string{"body": fmt.Sprintf("%#v", r.Body)}),
-					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, nil),
-				)
+func newSpanContext() trace.SpanContext {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	// crypto/rand.Read never returns an error.
+	rand.Read(traceID[:])
+	rand.Read(spanID[:])
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+}
 
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(fmt.Sprintf(`{"reference_id": "%s"}`, refID)))
+// Recover logs panic of handler with stack trace and responds with 500 Internal Server Error.
+func Recover(handler http.Handler) http.HandlerFunc {
+	return RecoverWith()(handler)
+}
+
+// RecoverWith returns Recover tuned by options: WithRecoverResponse, WithRepanicAbortHandler, WithOnPanic.
+func RecoverWith(opts ...Option) func(http.Handler) http.HandlerFunc {
+	o := newOptions(opts)
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			// Response can't be written to connection taken over by handler.
+			var rw *responseWriter
+			if isUpgrade(r) {
+				rw = newResponseWriter(w, 0)
+				w = rw
 			}
-		}()
+			defer func() {
+				if v := recover(); v != nil {
+					if v == http.ErrAbortHandler && o.repanicAbortHandler() {
+						panic(v)
+					}
+					refID := GetReferenceID(r)
+					user, tenant := GetUser(r), GetTenant(r)
+
+					err, ok := v.(error)
+					if !ok {
+						err = fmt.Errorf("%v", v)
+					}
+					log.Log(
+						"failed handler.ServeHTTP",
+						log.ReferenceID(refID),
+						log.Trace(r.Context()),
+						log.User(user),
+						log.Tenant(tenant),
+						log.Error(err),
+						log.Context(map[string]string{
+							"panic_type": fmt.Sprintf("%T", v),
+							"stack":      panicStack(debug.Stack()),
+						}),
+						log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, nil),
+						log.ClientIP(GetClientIP(r)),
+					)
+					if onPanic := o.panicCallback(); onPanic != nil {
+						onPanic(r, v)
+					}
+
+					if rw != nil && rw.hijacked {
+						return
+					}
+					contentType, tmpl := o.recoverResponse()
+					if tmpl == nil {
+						problem.Write(w, r, http.StatusInternalServerError)
+						return
+					}
+					writeRecoverResponse(w, contentType, tmpl, refID)
+				}
+			}()
+
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+// Response to panicked request is problem+json with reference id (see package problem).
+// If RecoverTemplate is set, response is rendered by it with RecoverContentType instead.
+// Template gets struct with ReferenceID field.
+// Customize them for project in init function:
+//   middleware.RecoverContentType = "text/plain"
+//   middleware.RecoverTemplate = template.Must(template.New("").Parse("Internal error {{.ReferenceID}}"))
+var (
+	RecoverContentType string
+	RecoverTemplate    *template.Template
+)
+
+// Handlers panic with http.ErrAbortHandler to abort response, e.g. when client is gone.
+// If RepanicAbortHandler is true, Recover passes such panic to net/http,
+// which closes connection silently, otherwise it's handled as any other panic.
+var RepanicAbortHandler = false
+
+// OnPanic is called by Recover with panic value after it's logged,
+// e.g. to alert by means of project. It must not panic itself.
+var OnPanic func(r *http.Request, v interface{})
+
+func writeRecoverResponse(w http.ResponseWriter, contentType string, tmpl *template.Template, refID string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusInternalServerError)
+	// Nothing can be done if client doesn't get response.
+	tmpl.Execute(w, struct{ ReferenceID string }{refID})
+}
 
-		handler.ServeHTTP(w, r)
+// panicStack trims frames of Recover and runtime from stack trace,
+// so it starts from the function which panicked.
+func panicStack(stack []byte) string {
+	// Frame of runtime.gopanic is printed as "panic(...)" followed by line with its file.
+	i := bytes.Index(stack, []byte("\npanic("))
+	if i < 0 {
+		return string(stack)
+	}
+	rest := stack[i+1:]
+	for n := 0; n < 2; n++ {
+		j := bytes.IndexByte(rest, '\n')
+		if j < 0 {
+			return string(stack)
+		}
+		rest = rest[j+1:]
 	}
+	return string(rest)
 }
 
 // It's preferable to log requests and responses of such handlers that have side effects.
@@ -59,100 +231,176 @@ func Recover(handler http.Handler) http.HandlerFunc {
 // The most flexible approach is to selectively wrap a handler func with the RequestResponseLogger.
 // That is why the signature of the RequestResponseLogger differs from the signature of the Recover.
 // The last one wraps a router, not a handler func.
-// TODO maybe run log.Log in goroutine?
+// Transaction event might be emitted in background goroutine, see Async.
 func RequestResponseLogger(handler http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var reqBody []byte
-		var respBody []byte
-		var err error
+	return RequestResponseLoggerWith()(handler)
+}
+
+// RequestResponseLoggerWith returns RequestResponseLogger tuned by options: WithBodyLimit, WithSkip, WithBodiesOnError.
+func RequestResponseLoggerWith(opts ...Option) func(http.HandlerFunc) http.HandlerFunc {
+	o := newOptions(opts)
+	return func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			received := time.Now()
+			refID := GetReferenceID(r)
+			user, tenant := GetUser(r), GetTenant(r)
+			// Inner middlewares and handler may add fields to transaction event.
+			r = r.WithContext(log.WithFields(r.Context()))
+			r, keepBodies := withKeepBodies(r)
 
-		refID := GetReferenceID(r)
-		user := GetUser(r)
+			// Request body is streamed to handler, only its beginning is captured.
+			// Upgrade requests have no body, connection is taken over by handler.
+			var reqBody *requestBody
+			upgrade := isUpgrade(r)
+			if r.Body != nil && r.Body != http.NoBody && !upgrade {
+				reqBody = newRequestBody(r.Body, o.captureLimit())
+				r.Body = reqBody
+			}
+
+			rw := newResponseWriter(w, o.captureLimit())
+			if upgrade {
+				rw.onHijack = func(conn net.Conn, brw *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter) {
+					return hijackWebSocket(conn, brw, func(c *wsConn) { logWebSocket(r, c) })
+				}
+			}
+			disconnect := watchDisconnect(r.Context())
+			start := time.Now()
+			handler(rw, r)
+			latency := time.Since(start)
+			disconnectedAt, disconnected := disconnect.disconnected()
+
+			var reqCaptured []byte
+			reqSize := 0
+			if reqBody != nil {
+				reqCaptured, reqSize = reqBody.captured.Bytes(), reqBody.totalSize(r.ContentLength)
+				if reqBody.err != nil && !IsBodyTooLarge(reqBody.err) {
+					log.AddFields(r.Context(), log.Context(map[string]string{"request_body_error": reqBody.err.Error()}))
+				}
+			}
 
-		if r.Body != nil {
-			reqBody, err = ioutil.ReadAll(r.Body)
-			if err != nil {
+			// Upgraded connection is logged on teardown, see logWebSocket.
+			if rw.hijacked && upgrade {
+				return
+			}
+
+			// Failed write to disconnected client is expected, it's marked in transaction event.
+			if rw.err != nil && !disconnected {
 				log.Log(
-					"failed ioutil.ReadAll",
+					"failed w.Write",
 					log.ReferenceID(refID),
+					log.Trace(r.Context()),
 					log.User(user),
-					log.Error(err),
-					log.Context(map[string]string{"body": fmt.Sprintf("%#v", r.Body)}),
-					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, nil),
+					log.Tenant(tenant),
+					log.Error(rw.err),
+					log.PartialRequest(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqCaptured, reqSize),
+					log.ClientIP(GetClientIP(r)),
+					log.PartialResponse(rw.statusCode(), w.Header(), rw.body.Bytes(), rw.size),
 				)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(fmt.Sprintf(`{"reference_id": "%s"}`, refID)))
-				return
 			}
-			r.Body = ioutil.NopCloser(bytes.NewBuffer(reqBody))
-		}
-
-		rec := httptest.NewRecorder()
-		handler(rec, r)
 
-		if rec.Body != nil {
-			respBody, err = ioutil.ReadAll(rec.Body)
-			if err != nil {
+			if rw.misused() {
 				log.Log(
-					"failed ioutil.ReadAll",
-					log.Error(err),
+					"misused http.ResponseWriter",
+					log.Warning(),
 					log.ReferenceID(refID),
+					log.Trace(r.Context()),
 					log.User(user),
-					log.Context(map[string]string{"body": fmt.Sprintf("%#v", rec.Body)}),
-					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqBody),
-					log.Response(rec.Code, rec.Header(), nil),
+					log.Tenant(tenant),
+					log.Context(rw.misuse()),
+					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
 				)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(fmt.Sprintf(`{"reference_id": "%s"}`, refID)))
+			}
+
+			if o.skipped(r, rw.statusCode()) {
 				return
 			}
-		}
 
-		for k, v := range rec.Header() {
-			w.Header()[k] = v
-		}
-		w.WriteHeader(rec.Code)
-		if len(respBody) > 0 {
-			_, err = w.Write(respBody)
-			if err != nil {
-				log.Log(
-					"failed w.Write",
-					log.ReferenceID(refID),
-					log.User(user),
-					log.Error(err),
-					log.Context(map[string]string{"body": fmt.Sprintf("%#v", respBody)}),
-					log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqBody),
-					log.Response(rec.Code, rec.Header(), respBody),
+			loggedReqBody, loggedRespBody := reqCaptured, rw.body.Bytes()
+			if o.logBodiesOnError() && rw.statusCode() < 400 && !*keepBodies {
+				loggedReqBody, loggedRespBody = nil, nil
+			}
+
+			// Total time includes reading of request body and other work of middleware,
+			// it's measured before emission, so logging itself is not counted.
+			total := time.Since(received)
+
+			// Headers are copied: outer middlewares may change them while event is emitted.
+			reqHeaders, respHeaders := r.Header.Clone(), w.Header().Clone()
+			status, size, ctx := rw.statusCode(), rw.size, r.Context()
+			clientIP := GetClientIP(r)
+			setters := []log.SetFieldValue{
+				log.ReferenceID(refID),
+				log.Trace(ctx),
+				log.User(user),
+				log.Tenant(tenant),
+				log.PartialRequest(r.Method, r.Host, r.URL.Path, r.URL.Query(), reqHeaders, loggedReqBody, reqSize),
+				log.ClientIP(clientIP),
+				log.Route(o.route),
+				log.Latency(latency),
+				log.Duration("handler_ms", latency),
+				log.Duration("total_ms", total),
+				// Sizes are top-level numbers even if bodies aren't logged, to sum traffic by them.
+				log.Int("request_bytes", reqSize),
+				log.Int("response_bytes", size),
+				log.Context(rw.context()),
+				log.Fields(ctx),
+			}
+			if disconnected {
+				// Nothing was sent to client, net/http would respond with 200 which is misleading.
+				if rw.status == 0 {
+					status = problem.StatusClientClosedRequest
+				}
+				setters = append(setters,
+					log.Bool("client_disconnected", true),
+					log.Duration("disconnected_after_ms", disconnectedAt.Sub(received)),
 				)
 			}
+			setters = append(setters, log.PartialResponse(status, respHeaders, loggedRespBody, size))
+			emit(func() {
+				log.Log(fmt.Sprintf("in '%s %s' %d", r.Method, r.Host+r.URL.Path, status), setters...)
+			})
 		}
+	}
+}
 
-		log.Log(
-			fmt.Sprintf("in '%s %s' %d", r.Method, r.Host+r.URL.Path, rec.Code),
-			log.ReferenceID(refID),
-			log.User(user),
-			log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, reqBody),
-			log.Response(rec.Code, rec.Header(), respBody),
-		)
+// WebSocket connection has no response to log, its duration and close code are logged on teardown.
+func logWebSocket(r *http.Request, c *wsConn) {
+	setters := []log.SetFieldValue{
+		log.FromContext(r.Context()),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, nil),
+		log.ClientIP(GetClientIP(r)),
+		log.Duration("duration_ms", time.Since(c.upgraded)),
 	}
+	if code := c.closeCode(); code != 0 {
+		setters = append(setters, log.Int("close_code", code))
+	}
+	log.Log("ws upgrade", setters...)
 }
 
+// Reference id and user of request are kept in its context by log package,
+// so httpclient and slog handler can take them from context too.
+
 func GetReferenceID(r *http.Request) string {
-	refID := ""
-	v := r.Context().Value("reference_id")
-	if v != nil {
-		refID = v.(string)
-	}
-	return refID
+	return log.ReferenceIDFromContext(r.Context())
 }
 
 func GetUser(r *http.Request) string {
-	u := ""
-	v := r.Context().Value("user")
-	if v != nil {
-		u = v.(string)
-	}
-	return u
+	return log.UserFromContext(r.Context())
+}
+
+// SetReferenceID returns shallow copy of request with reference id in its context.
+func SetReferenceID(r *http.Request, referenceID string) *http.Request {
+	return r.WithContext(log.WithReferenceID(r.Context(), referenceID))
+}
+
+// SetUser returns shallow copy of request with user in its context.
+// Authentication middlewares of project set user by it.
+func SetUser(r *http.Request, user string) *http.Request {
+	reportUser(r.Context(), user)
+	return r.WithContext(WithUser(r.Context(), user))
+}
+
+// WithUser returns copy of ctx carrying user, e.g. for background jobs.
+func WithUser(ctx context.Context, user string) context.Context {
+	return log.WithUser(ctx, user)
 }
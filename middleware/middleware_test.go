@@ -2,12 +2,28 @@ package middleware
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
 	"io/ioutil"
 	"lib/log"
+	"lib/problem"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"text/template"
+	"time"
 )
 
 func handler(w http.ResponseWriter, r *http.Request) {
@@ -52,3 +68,970 @@ func BenchmarkWithRequestResponseLoggerToStdout(b *testing.B) {
 		RequestResponseLogger(handler)(w, r)
 	}
 }
+
+func TestRequestResponseLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	r := httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"body": "abc"}`))
+	w := httptest.NewRecorder()
+	RequestResponseLogger(handler)(w, r)
+
+	if w.Code != 200 || w.Body.String() != `{"body_length": 15}` {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Request.Body != `{"body": "abc"}` || e.Response.StatusCode != 200 || e.Response.Body != `{"body_length": 15}` {
+		t.Errorf("unexpected event %+v", e)
+	}
+	handlerMS, _ := e.Context["handler_ms"].(float64)
+	totalMS, _ := e.Context["total_ms"].(float64)
+	if handlerMS <= 0 || totalMS < handlerMS {
+		t.Errorf("unexpected latency %v %v", handlerMS, totalMS)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	stack := Use(trace("first"), trace("second"))
+	stack.Use(trace("third")).ThenFunc(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if fmt.Sprint(order) != "[first second third]" {
+		t.Errorf("unexpected order %v", order)
+	}
+	if len(stack) != 2 {
+		t.Error("stack is modified by Use")
+	}
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("secret")
+	auth := JWT(JWTConfig{Key: secret, Issuer: "auth"})
+	var user string
+	h := auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+	}))
+
+	claims := jwt.RegisteredClaims{Subject: "boris", Issuer: "auth", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != 200 || user != "boris" {
+		t.Errorf("valid token: got %d, user %q", w.Code, user)
+	}
+
+	claims.Issuer = "attacker"
+	token, _ = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	h(w, r)
+	if w.Code != 401 {
+		t.Errorf("token of unknown issuer: got %d", w.Code)
+	}
+}
+
+func TestAccessLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	// Authentication wraps route, not router: user is set in request which AccessLogger doesn't see.
+	auth := BasicAuth("test", func(username, password string) bool { return password == "secret" })
+	h := AccessLogger(auth(http.HandlerFunc(handler)))
+	r := httptest.NewRequest("GET", "/article", nil)
+	r.SetBasicAuth("alice", "secret")
+	h(httptest.NewRecorder(), r)
+
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.User != "alice" || e.Response.StatusCode != 200 {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestReferenceIDTrust(t *testing.T) {
+	var ref string
+	h := ReferenceID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref = GetReferenceID(r)
+	}))
+	tests := []struct {
+		remoteAddr string
+		header     string
+		accepted   bool
+	}{
+		{"10.1.2.3:5000", "abc-123", true},
+		{"203.0.113.5:5000", "abc-123", false},
+		{"10.1.2.3:5000", `"><script>`, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		r.Header.Set("Reference-ID", tt.header)
+		h(httptest.NewRecorder(), r)
+		if (ref == tt.header) != tt.accepted || ref == "" {
+			t.Errorf("%s %q: got reference id %q", tt.remoteAddr, tt.header, ref)
+		}
+	}
+}
+
+func TestGunzipRequest(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write([]byte(`{"body": "abc"}`))
+	zw.Close()
+	compressed := body.Len()
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Encoding", "GZIP")
+	w := httptest.NewRecorder()
+	GunzipRequest(RequestResponseLogger(handler))(w, r)
+
+	if w.Code != 200 || w.Body.String() != `{"body_length": 15}` {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if r.Header.Get("Content-Encoding") != "GZIP" {
+		t.Error("header of caller's request is changed")
+	}
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Request.Body != `{"body": "abc"}` {
+		t.Errorf("unexpected request body %q", e.Request.Body)
+	}
+	if e.Context["compressed_bytes"] != float64(compressed) || e.Context["decompressed_bytes"] != float64(15) {
+		t.Errorf("unexpected context %v", e.Context)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+	defer func(s []SkipFunc) { Skip = s }(Skip)
+	Skip = []SkipFunc{SkipPaths("/healthz"), SkipStatuses(3), SkipHeader("User-Agent", "probe")}
+
+	for _, tc := range []struct {
+		path   string
+		agent  string
+		logged bool
+	}{
+		{"/healthz/ready", "", false},
+		{"/items", "probe", false},
+		{"/items", "curl", true},
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.Header.Set("User-Agent", tc.agent)
+		RequestResponseLogger(handler)(httptest.NewRecorder(), r)
+		if logged := buf.Len() > 0; logged != tc.logged {
+			t.Errorf("%s %s: logged %v", tc.path, tc.agent, logged)
+		}
+	}
+}
+
+func TestBodiesOnError(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+	defer func(b bool) { BodiesOnError = b }(BodiesOnError)
+	BodiesOnError = true
+
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		logged  bool
+	}{
+		{handler, false},
+		{func(w http.ResponseWriter, r *http.Request) { KeepBodies(r); handler(w, r) }, true},
+		{func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(400)
+			w.Write([]byte("bad"))
+		}, true},
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"body": "abc"}`))
+		RequestResponseLogger(tc.handler)(httptest.NewRecorder(), r)
+		e, err := log.Decode(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logged := e.Request.Body != "" && e.Response.Body != ""; logged != tc.logged || e.Request.BodySize != 15 {
+			t.Errorf("unexpected event %+v %+v", e.Request, e.Response)
+		}
+	}
+}
+
+func TestAsync(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+	defer func(a bool) { Async = a }(Async)
+	Async = true
+
+	r := httptest.NewRequest("POST", "/", bytes.NewBufferString(`{"body": "abc"}`))
+	RequestResponseLogger(handler)(httptest.NewRecorder(), r)
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Request.Body != `{"body": "abc"}` || e.Response.StatusCode != 200 {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestGetClientIP(t *testing.T) {
+	defer func(n int) { TrustedProxies = n }(TrustedProxies)
+
+	for _, tc := range []struct {
+		proxies int
+		header  string
+		value   string
+		ip      string
+	}{
+		{0, "X-Forwarded-For", "1.1.1.1", "10.0.0.1"},
+		{1, "X-Forwarded-For", "6.6.6.6, 1.1.1.1", "1.1.1.1"},
+		{2, "X-Forwarded-For", "6.6.6.6, 1.1.1.1, 10.0.0.2", "1.1.1.1"},
+		{3, "X-Forwarded-For", "1.1.1.1", "1.1.1.1"},
+		{1, "Forwarded", `for=6.6.6.6, for="[2001:db8::1]:4711";proto=https`, "2001:db8::1"},
+		{1, "Forwarded", "for=unknown", "10.0.0.1"},
+		{1, "X-Real-IP", "1.1.1.1", "1.1.1.1"},
+	} {
+		TrustedProxies = tc.proxies
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set(tc.header, tc.value)
+		if ip := GetClientIP(r); ip != tc.ip {
+			t.Errorf("%d %s: %s: got %s, want %s", tc.proxies, tc.header, tc.value, ip, tc.ip)
+		}
+	}
+}
+
+func panicky(w http.ResponseWriter, r *http.Request) {
+	panic("oops")
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	w := httptest.NewRecorder()
+	Recover(http.HandlerFunc(panicky))(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != 500 {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, _ := e.Context["stack"].(string)
+	if e.Error != "oops" || e.Context["panic_type"] != "string" || !strings.HasPrefix(stack, "lib/middleware.panicky(") {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestRecoverOptions(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = io.Discard
+	defer func(ct string, tmpl *template.Template, f func(*http.Request, interface{})) {
+		RecoverContentType, RecoverTemplate, OnPanic = ct, tmpl, f
+	}(RecoverContentType, RecoverTemplate, OnPanic)
+	RecoverContentType = "text/plain"
+	RecoverTemplate = template.Must(template.New("").Parse("Internal error {{.ReferenceID}}"))
+	var panicked interface{}
+	OnPanic = func(r *http.Request, v interface{}) { panicked = v }
+
+	w := httptest.NewRecorder()
+	r := SetReferenceID(httptest.NewRequest("GET", "/", nil), "abc")
+	Recover(http.HandlerFunc(panicky))(w, r)
+
+	if w.Code != 500 || w.Header().Get("Content-Type") != "text/plain" || w.Body.String() != "Internal error abc" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if panicked != "oops" {
+		t.Errorf("OnPanic got %v", panicked)
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = io.Discard
+
+	calls := 0
+	h := Idempotency(&MemoryStore{TTL: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(201)
+		w.Write([]byte("created"))
+	}))
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders", nil)
+		r.Header.Set("Idempotency-Key", "k1")
+		h(w, r)
+		if w.Code != 201 || w.Body.String() != "created" {
+			t.Errorf("unexpected response %d %s", w.Code, w.Body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times", calls)
+	}
+
+	// Nothing is written to disconnected client, response is not stored and retry runs handler.
+	calls = 0
+	h = Idempotency(NewMemoryStore(time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Context().Err() != nil {
+			return
+		}
+		w.WriteHeader(201)
+	}))
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, ctx := range []context.Context{canceled, context.Background()} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/orders", nil).WithContext(ctx)
+		r.Header.Set("Idempotency-Key", "k2")
+		h(w, r)
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times after disconnect, want 2", calls)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = io.Discard
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := ConcurrencyLimit(1, 0, time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/", nil))
+	close(release)
+	if w.Code != 503 || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { AuditWriter = w }(AuditWriter)
+	AuditWriter = &buf
+
+	h := Audit("article.update")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AuditResource(r, "article/42")
+		AuditChange(r, "title=old", "title=new")
+		w.WriteHeader(200)
+	}))
+	h(httptest.NewRecorder(), SetUser(httptest.NewRequest("PUT", "/article/42", nil), "alice"))
+
+	var e AuditEvent
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != "audit" || e.Actor != "alice" || e.Action != "article.update" || e.Resource != "article/42" ||
+		e.Outcome != "success" || e.Before != "title=old" || e.After != "title=new" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// Logging is disabled: audit event has nowhere to go, request is served anyway.
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	AuditWriter, log.Writer = nil, nil
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("PUT", "/article/42", nil))
+	if w.Code != 200 {
+		t.Errorf("got status %d, want 200", w.Code)
+	}
+}
+
+func TestResponseWriterMisuse(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	h := RequestResponseLogger(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+		w.WriteHeader(500)
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	if !strings.Contains(buf.String(), `"message":"misused http.ResponseWriter","level":"warning"`) {
+		t.Errorf("warning is not logged: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `"superfluous_write_headers":"1"`) {
+		t.Errorf("misuse is not in context: %s", buf.String())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	refID := ReferenceIDWith(WithHeader("X-Request-ID"), WithGenerator(func() string { return "fixed" }))
+	logger := RequestResponseLoggerWith(WithSkip(SkipStatuses(2)))
+	w := httptest.NewRecorder()
+	refID(logger(handler))(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Header().Get("X-Request-ID") != "fixed" || w.Header().Get("Reference-ID") != "" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+	if buf.Len() != 0 {
+		t.Errorf("skipped transaction is logged: %s", buf.String())
+	}
+
+	RequestResponseLoggerWith(WithRoute("/person/:name"))(handler)(httptest.NewRecorder(), httptest.NewRequest("GET", "/person/boris", nil))
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Request.Route != "/person/:name" || e.Request.Path != "/person/boris" {
+		t.Errorf("unexpected request %+v", e.Request)
+	}
+}
+
+func TestPartialCapture(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	body := strings.Repeat("a", 10000)
+	h := RequestResponseLoggerWith(WithBodyLimit(100))(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+
+	if w.Body.String() != body {
+		t.Errorf("body is not streamed through")
+	}
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Request.BodySize != 10000 || e.Request.CapturedBytes != 100 || e.Response.BodySize != 10000 || e.Response.CapturedBytes != 100 {
+		t.Errorf("unexpected event %+v %+v", e.Request, e.Response)
+	}
+	if e.Context["request_bytes"] != float64(10000) || e.Context["response_bytes"] != float64(10000) {
+		t.Errorf("unexpected sizes in context %v", e.Context)
+	}
+
+	// Body which handler didn't read is left alone, net/http may have closed it already.
+	buf.Reset()
+	unread := &readCounter{r: strings.NewReader(body)}
+	h = RequestResponseLoggerWith(WithBodyLimit(100))(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("POST", "/", unread))
+	if unread.reads != 0 {
+		t.Errorf("body is read %d times after handler", unread.reads)
+	}
+}
+
+type readCounter struct {
+	r     io.Reader
+	reads int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	r.reads++
+	return r.r.Read(p)
+}
+
+func TestWebSocket(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &syncWriter{w: &buf}
+
+	done := make(chan struct{})
+	server := httptest.NewServer(RecoverWith()(RequestResponseLogger(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer close(done)
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		// Masked close frame of client with code 1001.
+		frame := make([]byte, 8)
+		io.ReadFull(brw, frame)
+	})))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	mask := []byte{1, 2, 3, 4}
+	conn.Write([]byte{0x88, 0x82, mask[0], mask[1], mask[2], mask[3], 0x03 ^ mask[0], 0xe9 ^ mask[1]})
+	<-done
+
+	e, err := log.Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Message != "ws upgrade" || e.Context["close_code"] != float64(1001) {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestHMAC(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = io.Discard
+
+	body := `{"action": "opened"}`
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	h := HMAC(HMACConfig{Header: "X-Hub-Signature-256", Prefix: "sha256=", Secrets: [][]byte{[]byte("new"), []byte("old")}})(http.HandlerFunc(handler))
+	for _, tc := range []struct {
+		signature string
+		code      int
+	}{
+		{sign("new"), 200},
+		{sign("old"), 200},
+		{sign("forged"), 401},
+		{"", 401},
+	} {
+		r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+		r.Header.Set("X-Hub-Signature-256", tc.signature)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%q: got %d, want %d", tc.signature, w.Code, tc.code)
+		}
+		if tc.code == 200 && w.Body.String() != `{"body_length": 20}` {
+			t.Errorf("handler didn't get body: %s", w.Body)
+		}
+	}
+
+	// Body buffered by outer middleware is reused, not read into memory again.
+	var buffered io.ReadCloser
+	h = HMAC(HMACConfig{Header: "X-Hub-Signature-256", Prefix: "sha256=", Secrets: [][]byte{[]byte("new")}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != buffered {
+			t.Error("body is buffered again")
+		}
+		handler(w, r)
+	}))
+	r := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	r.Header.Set("X-Hub-Signature-256", sign("new"))
+	bufferBody(r)
+	buffered = r.Body
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != 200 || w.Body.String() != `{"body_length": 20}` {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+}
+
+type createPerson struct {
+	Name     string `json:"name" validate:"required,max=5"`
+	Email    string `json:"email" validate:"email"`
+	Password string `json:"password" validate:"min=8"`
+}
+
+func TestValidateJSON(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	var got *createPerson
+	h := ValidateJSON(func() interface{} { return &createPerson{} })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = JSONBody(r).(*createPerson)
+	}))
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "Bob", "email": "bob@example.org", "password": "12345678"}`)))
+	if w.Code != 200 || got == nil || got.Name != "Bob" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "Robert", "email": "bob", "password": "123"}`)))
+	want := `"errors":[{"field":"name","message":"must be at most 5 characters"},{"field":"email","message":"must be email address"},{"field":"password","message":"must be at least 8 characters"}]`
+	if w.Code != 422 || !strings.Contains(w.Body.String(), want) {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(buf.String(), `\"password\":\"123\"`) || !strings.Contains(buf.String(), log.Redacted) {
+		t.Errorf("password is not redacted: %s", buf.String())
+	}
+
+	// Typo in tag fails when middleware is created, not on request.
+	type order struct {
+		Items []struct {
+			Count int `json:"count" validate:"minimum=1"`
+		} `json:"items"`
+	}
+	func() {
+		defer func() {
+			if v := recover(); v == nil || !strings.Contains(fmt.Sprint(v), `unknown rule "minimum=1"`) {
+				t.Errorf("got panic %v, want unknown rule", v)
+			}
+		}()
+		ValidateJSON(func() interface{} { return &order{} })
+	}()
+}
+
+func TestTenant(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	var tenant string
+	h := Tenant(TenantFromHeader("X-Tenant-ID"), TenantFromSubdomain("example.org"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = GetTenant(r)
+		log.Log("handled", log.FromContext(r.Context()))
+	}))
+	for _, tc := range []struct {
+		host, header, want string
+	}{
+		{"acme.example.org", "", "acme"},
+		{"acme.example.org:8080", "globex", "globex"},
+		{"example.org", "", ""},
+		{"a.b.example.org", "", ""},
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = tc.host
+		if tc.header != "" {
+			r.Header.Set("X-Tenant-ID", tc.header)
+		}
+		h(httptest.NewRecorder(), r)
+		if tenant != tc.want {
+			t.Errorf("%s %q: got %q, want %q", tc.host, tc.header, tenant, tc.want)
+		}
+		if tc.want != "" && !strings.Contains(buf.String(), `"tenant":"`+tc.want+`"`) {
+			t.Errorf("tenant isn't logged: %s", buf.String())
+		}
+	}
+
+	// Transaction event has tenant whether Tenant wraps logger or is wrapped by it.
+	tenantMiddleware := Tenant(TenantFromHeader("X-Tenant-ID"))
+	for name, h := range map[string]http.Handler{
+		"outer":              tenantMiddleware(RequestResponseLogger(handler)),
+		"inner":              RequestResponseLogger(tenantMiddleware(http.HandlerFunc(handler))),
+		"inner AccessLogger": AccessLogger(tenantMiddleware(http.HandlerFunc(handler))),
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Tenant-ID", "acme")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		e, err := log.Decode(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(e.Message, "in ") || e.Tenant != "acme" {
+			t.Errorf("%s: got tenant %q of event %q, want acme", name, e.Tenant, e.Message)
+		}
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	h := RequestResponseLogger(problem.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		disconnect()
+		return r.Context().Err()
+	}).ServeHTTP)
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/report", nil).WithContext(ctx))
+
+	out := buf.String()
+	for _, want := range []string{`"client disconnected"`, `"client_disconnected":true`, `"disconnected_after_ms":`, `"status_code":499`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %s in %s", want, out)
+		}
+	}
+	if strings.Contains(out, `"error"`) {
+		t.Errorf("disconnect is logged as error: %s", out)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &syncWriter{w: &buf}
+
+	var calls int64
+	release := make(chan struct{})
+	h := Coalesce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		w.Write([]byte("report"))
+	}))
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, 5)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			h(w, httptest.NewRequest("GET", "/report?day=1", nil))
+		}(recorders[i])
+	}
+	// Let all requests join the flight.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt64(&calls); calls != 1 {
+		t.Errorf("handler is called %d times", calls)
+	}
+	for _, w := range recorders {
+		if w.Body.String() != "report" {
+			t.Errorf("got %q", w.Body)
+		}
+	}
+	if !strings.Contains(buf.String(), `"coalesced":4`) {
+		t.Errorf("coalesced count isn't logged: %s", buf.String())
+	}
+}
+
+func TestCoalesceCanceledLeader(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &syncWriter{w: io.Discard}
+
+	var calls int64
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := Coalesce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		// Handler of disconnected client writes nothing.
+		if r.Context().Err() != nil {
+			return
+		}
+		w.Write([]byte("report"))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h(httptest.NewRecorder(), httptest.NewRequest("GET", "/report?day=1", nil).WithContext(ctx))
+	}()
+	<-started
+	recorders := make([]*httptest.ResponseRecorder, 3)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			h(w, httptest.NewRequest("GET", "/report?day=1", nil))
+		}(recorders[i])
+	}
+	// Let waiters join the flight.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+	wg.Wait()
+
+	for _, w := range recorders {
+		if w.Body.String() != "report" || w.Header().Get("Coalesced") != "" {
+			t.Errorf("got %q, headers %v", w.Body, w.Header())
+		}
+	}
+}
+
+func TestLocale(t *testing.T) {
+	supported := []string{"en", "de", "fr-CA"}
+	for header, want := range map[string]string{
+		"":                       "en",
+		"de":                     "de",
+		"de-AT, en;q=0.5":        "de",
+		"ja, fr;q=0.9, de;q=0.8": "fr-CA",
+		"DE;q=0.1, en-GB;q=0.2":  "en",
+		"ja, *;q=0.1":            "en",
+		"de;q=0, fr-ca":          "fr-CA",
+	} {
+		var got string
+		h := Locale(supported...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = GetLocale(r)
+		}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", header)
+		h(httptest.NewRecorder(), r)
+		if got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestShadow(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	sw := &syncWriter{w: &buf}
+	log.Writer = sw
+	logged := func() string {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		return buf.String()
+	}
+
+	shadowed := make(chan string, 1)
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		shadowed <- r.Header.Get("Shadow") + " " + r.URL.RequestURI() + " " + string(body)
+		w.WriteHeader(500)
+	}))
+	defer canary.Close()
+
+	h := Shadow(ShadowConfig{BaseURL: canary.URL, Percent: 100})(http.HandlerFunc(handler))
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/orders?dry=1", strings.NewReader("order")))
+	if w.Body.String() != `{"body_length": 5}` {
+		t.Errorf("handler didn't get body: %s", w.Body)
+	}
+	select {
+	case got := <-shadowed:
+		if got != "true /orders?dry=1 order" {
+			t.Errorf("got shadow request %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request isn't replayed")
+	}
+	// Divergence is logged after response of canary.
+	for i := 0; i < 100 && !strings.Contains(logged(), "shadow divergence"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if out := logged(); !strings.Contains(out, `"shadow_status":500`) {
+		t.Errorf("divergence isn't logged: %s", out)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	var timeout time.Duration
+	h := Deadline(100 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		timeout = time.Until(deadline)
+		<-r.Context().Done()
+	}))
+	for header, want := range map[string]time.Duration{
+		"X-Request-Timeout: 50ms": 50 * time.Millisecond,
+		"Grpc-Timeout: 20m":       20 * time.Millisecond,
+		"X-Request-Timeout: 1h":   100 * time.Millisecond,
+		"X-Request-Timeout: 10":   100 * time.Millisecond,
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		name, value, _ := strings.Cut(header, ": ")
+		r.Header.Set(name, value)
+		h(httptest.NewRecorder(), r)
+		if timeout > want || timeout < want-10*time.Millisecond {
+			t.Errorf("%s: got timeout %s", header, timeout)
+		}
+		if !strings.Contains(buf.String(), "exceeded request deadline") {
+			t.Errorf("%s: exceeded deadline isn't logged: %s", header, buf.String())
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+
+	h := ReferenceID(Nonce(&MemoryNonceStore{}, time.Minute)(http.HandlerFunc(handler)))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	var first string
+	for _, tc := range []struct {
+		nonce, timestamp string
+		code             int
+	}{
+		{"n1", now, 200},
+		{"n1", now, 401},
+		{"n2", stale, 401},
+		{"", now, 401},
+		{"n3", now, 200},
+	} {
+		r := httptest.NewRequest("POST", "/webhook", nil)
+		r.Header.Set("X-Nonce", tc.nonce)
+		r.Header.Set("X-Timestamp", tc.timestamp)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%q %s: got %d, want %d", tc.nonce, tc.timestamp, w.Code, tc.code)
+		}
+		if first == "" {
+			first = w.Header().Get("Reference-ID")
+		}
+	}
+	if !strings.Contains(buf.String(), `"original_reference_id":"`+first+`"`) {
+		t.Errorf("reference id of the first request isn't logged: %s", buf.String())
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	SecureHeaders(http.HandlerFunc(handler))(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("Content-Security-Policy") == "" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+
+	h := SecureHeadersWith(
+		WithSecurityHeader("content-security-policy", "default-src 'self'"),
+		WithSecurityHeader("X-Frame-Options", ""),
+	)(http.HandlerFunc(handler))
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Content-Security-Policy") != "default-src 'self'" || w.Header().Get("X-Frame-Options") != "" || w.Header().Get("Referrer-Policy") == "" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"lib/log"
+	"lib/problem"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers nonces of requests. Implementation must be safe for concurrent use,
+// Add must check and save nonce atomically (e.g. SET NX of Redis).
+// Shared store is required if service has several instances.
+type NonceStore interface {
+	// Add saves nonce with reference id of request for ttl if nonce is new,
+	// otherwise it returns reference id of request which used nonce first and false.
+	Add(nonce, referenceID string, ttl time.Duration) (string, bool)
+}
+
+// Nonce rejects replays of signed requests: request must have unique X-Nonce header and
+// X-Timestamp header (Unix time in seconds) within window from now, both covered by signature
+// of client. Nonces are kept for 2*window, older requests are rejected by timestamp anyway.
+// Put Nonce after HMAC, so forged requests don't fill store:
+//   router.Handler("POST", "/webhook", middleware.HMAC(config)(middleware.Nonce(store, 5*time.Minute)(webhook)))
+// Rejected requests get 401, replay is logged with reference id of its first occurrence.
+func Nonce(store NonceStore, window time.Duration) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			nonce := r.Header.Get("X-Nonce")
+			if nonce == "" {
+				rejectNonce(w, r, "no nonce", nil)
+				return
+			}
+			ts, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+			if err != nil {
+				rejectNonce(w, r, "invalid timestamp", nil)
+				return
+			}
+			if skew := time.Since(time.Unix(ts, 0)); skew > window || skew < -window {
+				rejectNonce(w, r, "timestamp is out of window", map[string]string{"skew": skew.String()})
+				return
+			}
+			if first, ok := store.Add(GetUser(r)+" "+nonce, GetReferenceID(r), 2*window); !ok {
+				rejectNonce(w, r, "replayed nonce", map[string]string{"original_reference_id": first})
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+// Rejection is not logged as Error: anyone can replay captured request.
+func rejectNonce(w http.ResponseWriter, r *http.Request, reason string, cnt map[string]string) {
+	c := map[string]string{"reason": reason, "nonce": r.Header.Get("X-Nonce")}
+	for k, v := range cnt {
+		c[k] = v
+	}
+	log.Log(
+		"rejected request nonce",
+		log.FromContext(r.Context()),
+		log.Context(c),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		log.ClientIP(GetClientIP(r)),
+	)
+	problem.Write(w, r, http.StatusUnauthorized)
+}
+
+// MemoryNonceStore is NonceStore in memory of process. Zero value is ready to use.
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]storedNonce
+	lastSweep time.Time
+}
+
+type storedNonce struct {
+	referenceID string
+	expires     time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]storedNonce)}
+}
+
+// Expired nonces are removed once per ttl, otherwise map grows with every request.
+func (s *MemoryNonceStore) Add(nonce, referenceID string, ttl time.Duration) (string, bool) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.nonces[nonce]; ok && now.Before(stored.expires) {
+		return stored.referenceID, false
+	}
+	if now.Sub(s.lastSweep) > ttl {
+		for k, stored := range s.nonces {
+			if now.After(stored.expires) {
+				delete(s.nonces, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if s.nonces == nil {
+		s.nonces = make(map[string]storedNonce)
+	}
+	s.nonces[nonce] = storedNonce{referenceID: referenceID, expires: now.Add(ttl)}
+	return "", true
+}
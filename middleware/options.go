@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"github.com/lithammer/shortuuid"
+	"lib/log"
+	"net/http"
+	"text/template"
+)
+
+// Option tunes middleware for particular route without forking its code:
+//   router.Handler("POST", "/upload", middleware.RequestResponseLoggerWith(middleware.WithBodyLimit(256))(upload))
+// Options are accepted by ReferenceIDWith, RecoverWith, RequestResponseLoggerWith and SecureHeadersWith,
+// options which don't apply to middleware are ignored.
+// Package variables (RecoverTemplate, Skip, BodiesOnError...) are defaults of options.
+type Option func(*options)
+
+type options struct {
+	// ReferenceID
+	header   string
+	generate func() string
+
+	// Recover
+	contentType  string
+	template     *template.Template
+	repanicAbort *bool
+	onPanic      func(r *http.Request, v interface{})
+
+	// RequestResponseLogger
+	bodyLimit     int
+	skip          []SkipFunc
+	bodiesOnError *bool
+	route         string
+
+	// SecureHeaders
+	securityHeaders map[string]string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHeader sets name of header of reference id, "Reference-ID" by default.
+func WithHeader(name string) Option {
+	return func(o *options) { o.header = name }
+}
+
+// WithGenerator sets generator of reference ids, shortuuid.New by default.
+// Generated ids must be valid, see ReferenceID.
+func WithGenerator(generate func() string) Option {
+	return func(o *options) { o.generate = generate }
+}
+
+// WithRecoverResponse sets response to panicked request, see RecoverTemplate.
+func WithRecoverResponse(contentType string, tmpl *template.Template) Option {
+	return func(o *options) { o.contentType, o.template = contentType, tmpl }
+}
+
+// WithRepanicAbortHandler overrides RepanicAbortHandler.
+func WithRepanicAbortHandler(repanic bool) Option {
+	return func(o *options) { o.repanicAbort = &repanic }
+}
+
+// WithOnPanic sets callback called instead of OnPanic.
+func WithOnPanic(onPanic func(r *http.Request, v interface{})) Option {
+	return func(o *options) { o.onPanic = onPanic }
+}
+
+// WithBodyLimit sets limit of captured response body, log.BodyLimit by default.
+// Logged bodies are limited by log.BodyLimit anyway.
+func WithBodyLimit(limit int) Option {
+	return func(o *options) { o.bodyLimit = limit }
+}
+
+// WithSkip adds predicates of transactions which are not logged, in addition to Skip.
+func WithSkip(skip ...SkipFunc) Option {
+	return func(o *options) { o.skip = append(o.skip, skip...) }
+}
+
+// WithBodiesOnError overrides BodiesOnError.
+func WithBodiesOnError(onError bool) Option {
+	return func(o *options) { o.bodiesOnError = &onError }
+}
+
+// WithRoute sets route of transaction event, a template of path ("/person/:name") which
+// events are aggregated by. Unlike path, it has low cardinality, the same as labels of metrics.
+func WithRoute(route string) Option {
+	return func(o *options) { o.route = route }
+}
+
+// WithSecurityHeader overrides value of one of SecurityHeaders or adds another header,
+// empty value disables header.
+func WithSecurityHeader(name, value string) Option {
+	return func(o *options) {
+		if o.securityHeaders == nil {
+			o.securityHeaders = make(map[string]string)
+		}
+		o.securityHeaders[http.CanonicalHeaderKey(name)] = value
+	}
+}
+
+// Defaults are read on each request, so package variables customized later still apply.
+
+func (o *options) referenceIDHeader() string {
+	if o.header != "" {
+		return o.header
+	}
+	return "Reference-ID"
+}
+
+func (o *options) newReferenceID() string {
+	if o.generate != nil {
+		return o.generate()
+	}
+	return shortuuid.New()
+}
+
+func (o *options) recoverResponse() (string, *template.Template) {
+	if o.template != nil {
+		return o.contentType, o.template
+	}
+	return RecoverContentType, RecoverTemplate
+}
+
+func (o *options) repanicAbortHandler() bool {
+	if o.repanicAbort != nil {
+		return *o.repanicAbort
+	}
+	return RepanicAbortHandler
+}
+
+func (o *options) panicCallback() func(r *http.Request, v interface{}) {
+	if o.onPanic != nil {
+		return o.onPanic
+	}
+	return OnPanic
+}
+
+func (o *options) captureLimit() int {
+	if o.bodyLimit > 0 {
+		return o.bodyLimit
+	}
+	return log.BodyLimit
+}
+
+func (o *options) skipped(r *http.Request, status int) bool {
+	for _, skip := range o.skip {
+		if skip(r, status) {
+			return true
+		}
+	}
+	return skipped(r, status)
+}
+
+func (o *options) logBodiesOnError() bool {
+	if o.bodiesOnError != nil {
+		return *o.bodiesOnError
+	}
+	return BodiesOnError
+}
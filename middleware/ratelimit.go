@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"golang.org/x/time/rate"
+	"lib/log"
+	"lib/problem"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KeyFunc returns key of client to limit requests by.
+// Requests with empty key are not limited.
+type KeyFunc func(r *http.Request) string
+
+// ByIP limits requests by IP address of client, see TrustedProxies.
+func ByIP(r *http.Request) string {
+	return GetClientIP(r)
+}
+
+// ByUser limits authenticated requests, put it after authentication middleware.
+func ByUser(r *http.Request) string {
+	return GetUser(r)
+}
+
+func ByAPIKey(r *http.Request) string {
+	return r.Header.Get("X-API-Key")
+}
+
+// RateLimit limits requests of each client (by key) with token bucket:
+// perSecond requests per second on average with bursts up to burst requests.
+// Throttled request is rejected with 429 and Retry-After header, and logged,
+// so abusive clients are visible in log.
+func RateLimit(perSecond float64, burst int, key KeyFunc) func(http.Handler) http.HandlerFunc {
+	l := &limiters{limit: rate.Limit(perSecond), burst: burst, buckets: make(map[string]*bucket)}
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			res := l.get(k, now).ReserveN(now, 1)
+			delay := res.DelayFrom(now)
+			if res.OK() && delay == 0 {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			res.CancelAt(now)
+
+			refID := GetReferenceID(r)
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if !res.OK() || retryAfter < 1 {
+				retryAfter = 1
+			}
+			log.Log(
+				"throttled request",
+				log.ReferenceID(refID),
+				log.Trace(r.Context()),
+				log.User(GetUser(r)),
+				log.Tenant(GetTenant(r)),
+				log.Context(map[string]string{"key": k, "limit": strconv.FormatFloat(perSecond, 'f', -1, 64), "burst": strconv.Itoa(burst)}),
+				log.Int("retry_after", retryAfter),
+				log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+				log.ClientIP(GetClientIP(r)),
+			)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			problem.Write(w, r, http.StatusTooManyRequests)
+		}
+	}
+}
+
+type limiters struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Buckets of clients idle for a minute are removed, otherwise map grows with every new client.
+// Idle bucket is full anyway, removal doesn't change limits.
+func (l *limiters) get(key string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"lib/httpclient"
+	"lib/log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ShadowConfig configures replay of production traffic to secondary backend, e.g. rewrite of service:
+//   middleware.ShadowConfig{BaseURL: "http://orders-v2.internal", Percent: 5}
+type ShadowConfig struct {
+	// BaseURL of secondary backend, path and query of request are appended to it.
+	BaseURL string
+	// Percent of requests to replay, from 0 to 100.
+	Percent float64
+	// Timeout of replayed request, 5 seconds by default.
+	Timeout time.Duration
+	// Requests with bigger body are not replayed, 1 MiB by default.
+	MaxBody int
+	// Replayed requests over limit are dropped, 100 by default.
+	MaxInFlight int
+}
+
+// Shadow replays percent of requests with their bodies to secondary backend after handler responds,
+// in background: client doesn't wait for it and never sees its response.
+// Replayed requests have "Shadow: true" header and are logged by httpclient.Send.
+// Status code of secondary backend different from the one of handler is logged as warning "shadow divergence".
+// Only idempotent or shadow-aware backends should get unsafe requests (POST...): they are replayed too.
+func Shadow(config ShadowConfig) func(http.Handler) http.HandlerFunc {
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.MaxBody == 0 {
+		config.MaxBody = 1 << 20
+	}
+	if config.MaxInFlight == 0 {
+		config.MaxInFlight = 100
+	}
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+	inFlight := make(chan struct{}, config.MaxInFlight)
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if rand.Float64()*100 >= config.Percent || isUpgrade(r) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				var err error
+				body, err = ioutil.ReadAll(io.LimitReader(r.Body, int64(config.MaxBody)+1))
+				// Handler gets the whole body whatever happens, it reports errors of reading itself.
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				if err != nil || len(body) > config.MaxBody {
+					handler.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			rw := newResponseWriter(w, 0)
+			handler.ServeHTTP(rw, r)
+			if rw.hijacked {
+				return
+			}
+
+			select {
+			case inFlight <- struct{}{}:
+			default:
+				return
+			}
+			// Context keeps reference id, user and trace of request, but not its cancellation.
+			ctx := context.WithoutCancel(r.Context())
+			req, err := http.NewRequestWithContext(ctx, r.Method, baseURL+r.URL.RequestURI(), bytes.NewReader(body))
+			if err != nil {
+				<-inFlight
+				log.Log("failed http.NewRequest", log.FromContext(ctx), log.Error(err))
+				return
+			}
+			req.Header = r.Header.Clone()
+			req.Header.Set("Shadow", "true")
+			primary := rw.statusCode()
+			go func() {
+				defer func() { <-inFlight }()
+				resp, err := httpclient.Send(req, config.Timeout, "")
+				if err != nil {
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode != primary {
+					log.Log(
+						"shadow divergence",
+						log.Warning(),
+						log.FromContext(ctx),
+						log.Int("primary_status", primary),
+						log.Int("shadow_status", resp.StatusCode),
+						log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+					)
+				}
+			}()
+		}
+	}
+}
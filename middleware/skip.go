@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SkipFunc reports whether transaction shouldn't be logged by RequestResponseLogger.
+// Skipped requests still pass through the middleware chain, only their transaction event is dropped.
+type SkipFunc func(r *http.Request, status int) bool
+
+// Skip lists predicates of transactions which are not logged, e.g. noisy health checks.
+// Transaction is skipped if any of predicates returns true.
+// Customize Skip for project in init function:
+//   middleware.Skip = []middleware.SkipFunc{middleware.SkipPaths("/healthz"), middleware.SkipStatuses(3)}
+var Skip []SkipFunc
+
+func skipped(r *http.Request, status int) bool {
+	for _, skip := range Skip {
+		if skip(r, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipPaths skips transactions with path starting with one of prefixes.
+func SkipPaths(prefixes ...string) SkipFunc {
+	return func(r *http.Request, status int) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipStatuses skips transactions with status of one of classes, e.g. 2 for 2xx.
+func SkipStatuses(classes ...int) SkipFunc {
+	return func(r *http.Request, status int) bool {
+		for _, class := range classes {
+			if status/100 == class {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipHeader skips transactions with request header of given value, e.g. User-Agent of probes.
+// Empty value matches any value of present header.
+func SkipHeader(name, value string) SkipFunc {
+	return func(r *http.Request, status int) bool {
+		v, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return false
+		}
+		return value == "" || len(v) > 0 && v[0] == value
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"lib/log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TenantFunc resolves tenant of request, it returns empty string if it can't.
+type TenantFunc func(r *http.Request) string
+
+// TenantFromHeader takes tenant from request header, e.g. X-Tenant-ID.
+// Clients can put any value in header: it must be set by trusted gateway,
+// or handler must check that user belongs to tenant.
+func TenantFromHeader(name string) TenantFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// TenantFromSubdomain takes tenant from subdomain of domain: "acme.example.org" -> "acme".
+func TenantFromSubdomain(domain string) TenantFunc {
+	suffix := "." + strings.TrimPrefix(domain, ".")
+	return func(r *http.Request) string {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		sub := strings.TrimSuffix(strings.ToLower(host), suffix)
+		if sub == host || sub == "" || strings.Contains(sub, ".") {
+			return ""
+		}
+		return sub
+	}
+}
+
+// TenantFromClaim takes tenant from string claim of JWT, put Tenant after JWT middleware.
+func TenantFromClaim(claim string) TenantFunc {
+	return func(r *http.Request) string {
+		v, _ := JWTClaims(r)[claim].(string)
+		return v
+	}
+}
+
+// Tenant resolves tenant of request by the first resolver which succeeds,
+// and saves it in request context, so all events of request are logged with it (see GetTenant).
+// Request without tenant is passed through, handlers decide whether tenant is required.
+//   router.Handler("GET", "/orders", middleware.Tenant(middleware.TenantFromClaim("org"), middleware.TenantFromSubdomain("example.org"))(orders))
+func Tenant(resolvers ...TenantFunc) func(http.Handler) http.HandlerFunc {
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			for _, resolve := range resolvers {
+				if tenant := resolve(r); tenant != "" {
+					r = SetTenant(r, tenant)
+					break
+				}
+			}
+			handler.ServeHTTP(w, r)
+		}
+	}
+}
+
+func GetTenant(r *http.Request) string {
+	return log.TenantFromContext(r.Context())
+}
+
+// SetTenant returns shallow copy of request with tenant in its context.
+// Tenant is added to transaction event of outer RequestResponseLogger or AccessLogger too.
+func SetTenant(r *http.Request, tenant string) *http.Request {
+	reportTenant(r.Context(), tenant)
+	log.AddFields(r.Context(), log.Tenant(tenant))
+	return r.WithContext(log.WithTenant(r.Context(), tenant))
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Tracing starts server span of request of route with tracer of global OpenTelemetry provider
+// (configure it by otel.SetTracerProvider in main). Incoming traceparent header becomes parent of span.
+// Span is saved in request context: log events get its trace and span ids (see log.Trace),
+// httpclient.Send continues the trace downstream.
+// Route is a template of path, span names must have low cardinality the same as labels of metrics:
+//   router.Handler("POST", "/person/:name", middleware.Tracing("/person/:name")(post))
+// Put Tracing after ReferenceID, reference id is saved in attributes of span.
+func Tracing(route string) func(http.Handler) http.HandlerFunc {
+	tracer := otel.Tracer("lib/middleware")
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method+" "+route,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("http.route", route),
+					attribute.String("url.path", r.URL.Path),
+					attribute.String("client.address", GetClientIP(r)),
+					attribute.String("reference_id", GetReferenceID(r)),
+				),
+			)
+			defer span.End()
+
+			rw := newResponseWriter(w, 0)
+			defer func() {
+				// Panic is recorded and passed on to Recover.
+				if v := recover(); v != nil {
+					span.RecordError(fmt.Errorf("panic: %v", v))
+					span.SetStatus(codes.Error, "panic")
+					panic(v)
+				}
+				status := rw.statusCode()
+				span.SetAttributes(attribute.Int("http.response.status_code", status))
+				// Client errors (4xx) are not errors of server span.
+				if status >= 500 {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+			}()
+			handler.ServeHTTP(rw, r.WithContext(ctx))
+		}
+	}
+}
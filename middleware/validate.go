@@ -0,0 +1,256 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lib/log"
+	"lib/problem"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSON decodes JSON body of request into new value returned by newValue (pointer to struct)
+// and validates it by `validate` tags of fields before handler runs:
+//   type createPerson struct {
+//       Name  string   `json:"name" validate:"required,max=100"`
+//       Email string   `json:"email" validate:"required,email"`
+//       Role  string   `json:"role" validate:"oneof=admin user"`
+//       Tags  []string `json:"tags" validate:"max=10"`
+//   }
+//   router.Handler("POST", "/person", middleware.ValidateJSON(func() interface{} { return &createPerson{} })(post))
+// Handler gets decoded value by JSONBody(r).(*createPerson), body of request is still readable too,
+// it is buffered once and shared with HMAC.
+// Rules: required (not zero), min and max (length of string/slice/map, value of number), email,
+// oneof (space-separated values), nested structs are validated too.
+// Invalid JSON is rejected with 400, invalid fields with 422 and list of field errors (see problem.FieldError).
+// Rejections are logged with body, values of sensitive keys are redacted (see log.RedactedBodyKeys).
+// Tags are checked once, when middleware is created: unknown or malformed rule panics at start of service.
+func ValidateJSON(newValue func() interface{}) func(http.Handler) http.HandlerFunc {
+	checkTags(reflect.TypeOf(newValue()), make(map[reflect.Type]bool))
+	return func(handler http.Handler) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var body []byte
+			if r.Body != nil {
+				var err error
+				body, err = bufferBody(r)
+				if IsBodyTooLarge(err) {
+					var maxBytesErr *http.MaxBytesError
+					errors.As(err, &maxBytesErr)
+					rejectBodyTooLarge(w, r, maxBytesErr.Limit)
+					return
+				}
+				if err != nil {
+					log.Log(
+						"failed ioutil.ReadAll",
+						log.FromContext(r.Context()),
+						log.Error(err),
+						log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+					)
+					problem.Write(w, r, http.StatusBadRequest)
+					return
+				}
+			}
+
+			v := newValue()
+			if err := json.Unmarshal(body, v); err != nil {
+				rejectInvalidBody(w, r, body, problem.New(http.StatusBadRequest).WithDetail("invalid JSON: "+err.Error()))
+				return
+			}
+			if errs := validate(reflect.ValueOf(v), ""); len(errs) > 0 {
+				rejectInvalidBody(w, r, body, problem.New(http.StatusUnprocessableEntity).WithErrors(errs))
+				return
+			}
+			handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jsonBodyKey{}, v)))
+		}
+	}
+}
+
+type jsonBodyKey struct{}
+
+// JSONBody returns value decoded and validated by ValidateJSON, nil if request isn't wrapped by it.
+func JSONBody(r *http.Request) interface{} {
+	return r.Context().Value(jsonBodyKey{})
+}
+
+// Invalid body is a mistake of client, it's not logged as Error.
+func rejectInvalidBody(w http.ResponseWriter, r *http.Request, body []byte, p *problem.Problem) {
+	reason := p.Detail
+	if len(p.Errors) > 0 {
+		fields := make([]string, 0, len(p.Errors))
+		for _, e := range p.Errors {
+			fields = append(fields, e.Field+" "+e.Message)
+		}
+		reason = strings.Join(fields, "; ")
+	}
+	log.Log(
+		"rejected request body",
+		log.FromContext(r.Context()),
+		log.Context(map[string]string{"reason": reason}),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), r.Header, log.RedactJSON(body)),
+		log.ClientIP(GetClientIP(r)),
+	)
+	p.Write(w, r)
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+func validate(v reflect.Value, path string) []problem.FieldError {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	var errs []problem.FieldError
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := jsonName(f)
+			if name == "-" {
+				continue
+			}
+			field := name
+			if path != "" {
+				field = path + "." + name
+			}
+			if tag := f.Tag.Get("validate"); tag != "" {
+				for _, rule := range strings.Split(tag, ",") {
+					if msg := checkRule(v.Field(i), rule); msg != "" {
+						errs = append(errs, problem.FieldError{Field: field, Message: msg})
+						// The first failed rule of field is enough.
+						break
+					}
+				}
+			}
+			errs = append(errs, validate(v.Field(i), field)...)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, validate(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// checkTags panics if `validate` tag of field of t (or of nested struct) has unknown or malformed rule.
+func checkTags(t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	// Recursive types are checked once.
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || jsonName(f) == "-" {
+			continue
+		}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			for _, rule := range strings.Split(tag, ",") {
+				if err := parseRule(rule); err != nil {
+					panic(fmt.Sprintf("middleware: %s in validate tag of %s.%s", err, t, f.Name))
+				}
+			}
+		}
+		checkTags(f.Type, seen)
+	}
+}
+
+func parseRule(rule string) error {
+	name, arg, _ := strings.Cut(rule, "=")
+	switch name {
+	case "required", "email":
+	case "min", "max":
+		if _, err := strconv.ParseFloat(arg, 64); err != nil {
+			return fmt.Errorf("invalid rule %q", rule)
+		}
+	case "oneof":
+		if len(strings.Fields(arg)) == 0 {
+			return fmt.Errorf("invalid rule %q", rule)
+		}
+	default:
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	return nil
+}
+
+// checkRule returns message of failed rule, empty string if value is valid.
+// Rules other than required don't apply to zero (absent) values.
+// Rule is already checked by parseRule.
+func checkRule(v reflect.Value, rule string) string {
+	name, arg, _ := strings.Cut(rule, "=")
+	if name == "required" {
+		if v.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+	if v.IsZero() {
+		return ""
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch name {
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(arg, 64)
+		size, unit := measure(v)
+		if name == "min" && size < limit {
+			return fmt.Sprintf("must be at least %s%s", arg, unit)
+		}
+		if name == "max" && size > limit {
+			return fmt.Sprintf("must be at most %s%s", arg, unit)
+		}
+	case "email":
+		if v.Kind() == reflect.String && !emailPattern.MatchString(v.String()) {
+			return "must be email address"
+		}
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, allowed := range strings.Fields(arg) {
+			if s == allowed {
+				return ""
+			}
+		}
+		return "must be one of: " + strings.Join(strings.Fields(arg), ", ")
+	}
+	return ""
+}
+
+// measure returns length of string (in characters), slice and map, or value of number.
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	return 0, ""
+}
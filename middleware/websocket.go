@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isUpgrade reports whether request asks to switch protocol, e.g. to WebSocket.
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is hijacked connection of WebSocket. It watches frames passing through it
+// to get close code, and reports on teardown (the first Close).
+type wsConn struct {
+	net.Conn
+
+	upgraded   time.Time
+	in, out    frameReader
+	once       sync.Once
+	onTeardown func(c *wsConn)
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.feed(p[:n])
+	return n, err
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.feed(p[:n])
+	return n, err
+}
+
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.onTeardown(c) })
+	return err
+}
+
+// closeCode is status code of the first close frame, sent by either peer, 0 if there was none.
+func (c *wsConn) closeCode() int {
+	if code := c.in.getCloseCode(); code != 0 {
+		return code
+	}
+	return c.out.getCloseCode()
+}
+
+// hijackWebSocket wraps hijacked connection. Data already buffered by net/http
+// is passed through new reader, so frames in it are watched too.
+func hijackWebSocket(conn net.Conn, brw *bufio.ReadWriter, onTeardown func(c *wsConn)) (net.Conn, *bufio.ReadWriter) {
+	c := &wsConn{Conn: conn, upgraded: time.Now(), onTeardown: onTeardown}
+	var r io.Reader = c
+	if n := brw.Reader.Buffered(); n > 0 {
+		buffered, _ := brw.Reader.Peek(n)
+		c.in.feed(buffered)
+		r = io.MultiReader(bytes.NewReader(append([]byte(nil), buffered...)), c)
+	}
+	return c, bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(c))
+}
+
+// frameReader parses stream of WebSocket frames (RFC 6455) just enough to find the first close frame.
+// Frames might be split among reads arbitrarily, so it parses byte by byte.
+type frameReader struct {
+	mu sync.Mutex
+
+	header []byte
+	// Bytes of payload of current frame left to skip.
+	left uint64
+	// Close frame is being read: the first two bytes of its payload are code.
+	closing   bool
+	mask      []byte
+	pos       uint64
+	code      [2]byte
+	closeCode int
+}
+
+func (f *frameReader) getCloseCode() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closeCode
+}
+
+func (f *frameReader) feed(p []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(p) > 0 && f.closeCode == 0 {
+		if f.left > 0 {
+			n := uint64(len(p))
+			if n > f.left {
+				n = f.left
+			}
+			if f.closing {
+				for _, b := range p[:n] {
+					if f.pos < 2 {
+						if f.mask != nil {
+							b ^= f.mask[f.pos%4]
+						}
+						f.code[f.pos] = b
+					}
+					f.pos++
+				}
+				if f.pos >= 2 {
+					f.closeCode = int(binary.BigEndian.Uint16(f.code[:]))
+				}
+			}
+			f.left -= n
+			p = p[n:]
+			continue
+		}
+		f.header = append(f.header, p[0])
+		p = p[1:]
+		f.parseHeader()
+	}
+}
+
+// parseHeader starts payload of frame once its header is complete.
+func (f *frameReader) parseHeader() {
+	h := f.header
+	if len(h) < 2 {
+		return
+	}
+	size := 2
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	masked := h[1]&0x80 != 0
+	if masked {
+		size += 4
+	}
+	if len(h) < size {
+		return
+	}
+	switch length {
+	case 126:
+		length = uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		length = binary.BigEndian.Uint64(h[2:10])
+	}
+	f.mask = nil
+	if masked {
+		f.mask = append([]byte(nil), h[size-4:size]...)
+	}
+	f.closing = h[0]&0x0f == 0x8
+	f.pos = 0
+	f.left = length
+	f.header = f.header[:0]
+	if f.closing && length < 2 {
+		// Close frame without code: 1005 "no status received".
+		f.closeCode = 1005
+	}
+}
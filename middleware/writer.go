@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// responseWriter writes response through to client and captures its status code,
+// size and the first bytes of body for logging.
+// Unlike httptest.ResponseRecorder, response is not buffered: streaming works
+// and memory usage doesn't depend on size of response.
+type responseWriter struct {
+	http.ResponseWriter
+
+	status int
+	// Total bytes written to client.
+	size int
+	// The first limit bytes of body.
+	body  bytes.Buffer
+	limit int
+	// The first error of ResponseWriter.Write, e.g. client closed connection.
+	err error
+
+	// Response is streamed (Server-Sent Events, chunked download): handler flushed it.
+	flushed bool
+	// Connection is taken over by handler (websocket), nothing is known about response.
+	hijacked bool
+	// onHijack wraps hijacked connection to watch it, e.g. see hijackWebSocket.
+	onHijack func(conn net.Conn, brw *bufio.ReadWriter) (net.Conn, *bufio.ReadWriter)
+
+	// Mistakes of handler which net/http forgives silently: WriteHeader called
+	// after response is started, Write called after failed one.
+	superfluousWriteHeaders int
+	writesAfterError        int
+}
+
+func newResponseWriter(w http.ResponseWriter, limit int) *responseWriter {
+	return &responseWriter{ResponseWriter: w, limit: limit}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		w.superfluousWriteHeaders++
+	} else {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.err != nil {
+		w.writesAfterError++
+	}
+	if free := w.limit - w.body.Len(); free > 0 {
+		if free > len(p) {
+			free = len(p)
+		}
+		w.body.Write(p[:free])
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Status code sent to client. If handler writes nothing, net/http responds with 200.
+func (w *responseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Flush is required for streaming: Server-Sent Events, chunked downloads.
+// Streamed body is captured the same way, only the first bytes are logged.
+func (w *responseWriter) Flush() {
+	w.flushed = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is required for websockets.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not implemented by ResponseWriter")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		if w.onHijack != nil {
+			conn, rw = w.onHijack(conn, rw)
+		}
+	}
+	return conn, rw, err
+}
+
+// Unwrap is used by http.ResponseController to reach other features of original ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Context of transaction event describes how response was sent, if it wasn't a plain one.
+func (w *responseWriter) context() map[string]string {
+	if !w.flushed && !w.hijacked && !w.misused() {
+		return nil
+	}
+	c := make(map[string]string)
+	if w.flushed {
+		c["streamed"] = "true"
+	}
+	if w.hijacked {
+		c["hijacked"] = "true"
+	}
+	for k, v := range w.misuse() {
+		c[k] = v
+	}
+	return c
+}
+
+func (w *responseWriter) misused() bool {
+	return w.superfluousWriteHeaders > 0 || w.writesAfterError > 0
+}
+
+// Counts of mistakes of handler, they are logged as warning and in context of transaction event,
+// where tests of handlers can check them.
+func (w *responseWriter) misuse() map[string]string {
+	c := make(map[string]string)
+	if w.superfluousWriteHeaders > 0 {
+		c["superfluous_write_headers"] = strconv.Itoa(w.superfluousWriteHeaders)
+	}
+	if w.writesAfterError > 0 {
+		c["writes_after_error"] = strconv.Itoa(w.writesAfterError)
+	}
+	return c
+}
@@ -0,0 +1,175 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"lib/log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Email sends digest of events to recipients via SMTP.
+// The first event starts the digest, which is sent after Interval with all events of this period.
+// Identical events (same message and error) are grouped, so a burst of the same failure
+// results in one email with a counter instead of hundreds of emails.
+// Use it by pointer: &notifier.Email{...}.
+type Email struct {
+	// SMTP server address, host:port.
+	Addr string
+	// Optional, e.g. smtp.PlainAuth("", user, password, host).
+	Auth smtp.Auth
+	From string
+	To   []string
+	// Period of digest, 5 minutes by default.
+	Interval time.Duration
+	// Timeout of sending of digest by timer, 30 seconds by default. Flush is limited by its context instead.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	groups []*digestGroup
+}
+
+type digestGroup struct {
+	first log.Event
+	last  log.Event
+	count int
+}
+
+func (m *Email) Notify(e log.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.groups) == 0 {
+		interval := m.Interval
+		if interval == 0 {
+			interval = 5 * time.Minute
+		}
+		time.AfterFunc(interval, m.flush)
+	}
+	for _, g := range m.groups {
+		if g.first.Message == e.Message && g.first.Error == e.Error {
+			g.last = e
+			g.count++
+			return nil
+		}
+	}
+	m.groups = append(m.groups, &digestGroup{first: e, last: e, count: 1})
+	return nil
+}
+
+// Flush sends collected digest immediately, e.g. on shutdown.
+func (m *Email) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.send(ctx)
+}
+
+func (m *Email) send(ctx context.Context) error {
+	m.mu.Lock()
+	groups := m.groups
+	m.groups = nil
+	m.mu.Unlock()
+	if len(groups) == 0 {
+		return nil
+	}
+	return sendMail(ctx, m.Addr, m.Auth, m.From, m.To, m.digest(groups))
+}
+
+func (m *Email) flush() {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := m.send(ctx); err != nil {
+		logFailure(m, err, log.Event{Message: "digest"})
+	}
+}
+
+// sendMail is smtp.SendMail limited by ctx: smtp.SendMail has no timeout,
+// unresponsive SMTP server would block shutdown.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Cancellation without deadline interrupts blocked reads and writes too.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *Email) digest(groups []*digestGroup) []byte {
+	total := 0
+	for _, g := range groups {
+		total += g.count
+	}
+	service := groups[0].first.Service
+	if service == "" {
+		service = groups[0].first.Hostname
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %d errors\r\n", service, total)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, g := range groups {
+		fmt.Fprintf(&b, "%d x %s\r\n", g.count, g.first.Message)
+		b.WriteString(strings.ReplaceAll(Summary(g.first), "\n", "\r\n"))
+		b.WriteString("\r\n")
+		if g.count > 1 {
+			fmt.Fprintf(&b, "last: %s, reference_id: %s\r\n", g.last.Timestamp, g.last.ReferenceID)
+		}
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
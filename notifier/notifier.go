@@ -0,0 +1,213 @@
+// Package notifier sends notifications about logged errors to people on duty.
+// It's plugged into log package as a hook:
+//   func init() {
+//       slack := notifier.Slack{WebhookURL: os.Getenv("SLACK_WEBHOOK")}
+//       log.Hooks = append(log.Hooks, notifier.Hook(&notifier.Throttle{Notifier: slack}))
+//   }
+// Throttle keeps error storm from flooding the channel.
+// Notifications are sent in background, flush them on shutdown:
+//   log.Flushers = append(log.Flushers, log.FlushFunc(notifier.Flush))
+// Notifier failures are logged without Error field, otherwise a broken notifier
+// would be notified about itself endlessly.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"lib/log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier sends notification about event.
+type Notifier interface {
+	Notify(e log.Event) error
+}
+
+// Filter decides if notification about event should be sent.
+type Filter func(e *log.Event) bool
+
+// HasError is the default filter: event with error needs attention of people on duty.
+func HasError(e *log.Event) bool {
+	return e.Error != ""
+}
+
+// QueueSize limits events of each hook waiting to be sent, the oldest event is dropped
+// (and the drop is logged) when queue is full.
+var QueueSize = 100
+
+// Hook returns log hook which sends events accepted by all filters (HasError by default) to notifier.
+// Events are queued and sent one by one in background, so logging is not blocked by network
+// and error storm doesn't start thousands of requests at once (see QueueSize).
+func Hook(n Notifier, filters ...Filter) log.Hook {
+	if len(filters) == 0 {
+		filters = []Filter{HasError}
+	}
+	if _, ok := n.(log.Flusher); ok {
+		mu.Lock()
+		notifiers[n] = true
+		mu.Unlock()
+	}
+	queue := make(chan log.Event, QueueSize)
+	go func() {
+		for e := range queue {
+			if err := n.Notify(e); err != nil {
+				logFailure(n, err, e)
+			}
+			inFlight.add(-1)
+		}
+	}()
+	return func(e *log.Event) bool {
+		for _, accept := range filters {
+			if !accept(e) {
+				return true
+			}
+		}
+		inFlight.add(1)
+		for {
+			// Event is copied, as it's reused by other hooks and encoder after hook returns.
+			select {
+			case queue <- *e:
+				return true
+			default:
+			}
+			select {
+			case dropped := <-queue:
+				inFlight.add(-1)
+				log.Log(
+					"dropped notification",
+					log.ReferenceID(dropped.ReferenceID),
+					log.Context(map[string]string{"reason": "notification queue is full", "notifier": fmt.Sprintf("%T", n), "message": dropped.Message}),
+				)
+			default:
+			}
+		}
+	}
+}
+
+var (
+	inFlight pending
+
+	mu        sync.Mutex
+	notifiers = make(map[Notifier]bool)
+)
+
+// Flush waits for notifications being sent and flushes notifiers which buffer them (Email, Webhook, Throttle).
+func Flush(ctx context.Context) error {
+	if err := inFlight.wait(ctx); err != nil {
+		return err
+	}
+	mu.Lock()
+	var flushers []log.Flusher
+	for n := range notifiers {
+		flushers = append(flushers, n.(log.Flusher))
+	}
+	mu.Unlock()
+	var errs []error
+	for _, f := range flushers {
+		errs = append(errs, f.Flush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// pending counts notifications which are not sent yet. Unlike sync.WaitGroup,
+// it can be incremented while it's waited for: events are logged during shutdown too.
+type pending struct {
+	mu   sync.Mutex
+	n    int
+	zero chan struct{}
+}
+
+func (p *pending) add(delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n += delta
+	if p.n == 0 && p.zero != nil {
+		close(p.zero)
+		p.zero = nil
+	}
+}
+
+// wait waits until counter is zero.
+func (p *pending) wait(ctx context.Context) error {
+	p.mu.Lock()
+	if p.n == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.zero == nil {
+		p.zero = make(chan struct{})
+	}
+	zero := p.zero
+	p.mu.Unlock()
+	select {
+	case <-zero:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func logFailure(n Notifier, err error, e log.Event) {
+	log.Log(
+		"failed n.Notify",
+		log.ReferenceID(e.ReferenceID),
+		log.Context(map[string]string{"error": err.Error(), "notifier": fmt.Sprintf("%T", n), "message": e.Message}),
+	)
+}
+
+// Summary is a short plain text description of event for notification.
+func Summary(e log.Event) string {
+	lines := []string{e.Message}
+	add := func(name, value string) {
+		if value != "" {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	add("error", e.Error)
+	add("reference_id", e.ReferenceID)
+	add("service", e.Service)
+	add("host", e.Hostname)
+	add("timestamp", e.Timestamp)
+	keys := make([]string, 0, len(e.Context))
+	for k := range e.Context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, fmt.Sprint(e.Context[k]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notifiers don't use httpclient package: its failures are logged with Error field
+// and would trigger notification again.
+func postJSON(client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, b)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"lib/log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type counter struct {
+	mu     sync.Mutex
+	events []log.Event
+}
+
+func (c *counter) Notify(e log.Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, e)
+	return nil
+}
+
+func TestThrottle(t *testing.T) {
+	c := &counter{}
+	th := &Throttle{Notifier: c, PerMinute: 2}
+	th.Notify(log.Event{Message: "failed db.Get", Error: "timeout"})
+	th.Notify(log.Event{Message: "failed db.Get", Error: "timeout"})
+	th.Notify(log.Event{Message: "failed db.Put", Error: "timeout"})
+	th.Notify(log.Event{Message: "failed db.Delete", Error: "timeout"})
+	if len(c.events) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(c.events))
+	}
+
+	th.summarize()
+	if len(c.events) != 3 {
+		t.Fatalf("expected summary notification, got %d notifications", len(c.events))
+	}
+	summary := c.events[2]
+	if summary.Context["failed db.Get: timeout"] != 1 || summary.Context["failed db.Delete: timeout"] != 1 {
+		t.Errorf("unexpected summary: %v", summary.Context)
+	}
+}
+
+type blocking struct {
+	counter
+	release chan struct{}
+}
+
+func (b *blocking) Notify(e log.Event) error {
+	<-b.release
+	return b.counter.Notify(e)
+}
+
+func TestHook(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = &buf
+	defer func(size int) { QueueSize = size }(QueueSize)
+	QueueSize = 2
+
+	n := &blocking{release: make(chan struct{})}
+	hook := Hook(n)
+	for i := 0; i < 10; i++ {
+		hook(&log.Event{Message: "failed db.Get", Error: "timeout"})
+	}
+	hook(&log.Event{Message: "in 'GET /'"})
+	close(n.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// One event may be taken by sender before queue is full.
+	if len(n.events) < 2 || len(n.events) > 3 {
+		t.Errorf("got %d notifications, want queue size", len(n.events))
+	}
+	if !strings.Contains(buf.String(), "dropped notification") {
+		t.Errorf("drop isn't logged: %s", buf.String())
+	}
+}
+
+func TestEmailFlushTimeout(t *testing.T) {
+	// SMTP server accepts connection and never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	m := &Email{Addr: l.Addr().String(), From: "alerts@example.org", To: []string{"oncall@example.org"}, Interval: time.Hour}
+	m.Notify(log.Event{Message: "failed db.Get", Error: "timeout"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.Flush(ctx); err == nil {
+		t.Error("Flush to unresponsive server succeeded")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Flush took %v after deadline of its context", d)
+	}
+}
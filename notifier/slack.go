@@ -0,0 +1,20 @@
+package notifier
+
+import (
+	"fmt"
+	"lib/log"
+	"net/http"
+)
+
+// Slack posts summary of event to Slack incoming webhook
+// (https://api.slack.com/messaging/webhooks).
+type Slack struct {
+	WebhookURL string
+	// Optional, client with 10 seconds timeout is used by default.
+	Client *http.Client
+}
+
+func (s Slack) Notify(e log.Event) error {
+	text := fmt.Sprintf("```%s```", Summary(e))
+	return postJSON(s.Client, s.WebhookURL, map[string]string{"text": text})
+}
@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	"fmt"
+	"lib/log"
+	"net/http"
+	"net/url"
+)
+
+// Telegram sends summary of event to chat by bot (https://core.telegram.org/bots/api#sendmessage).
+// Bot must be added to the chat.
+type Telegram struct {
+	// Token given by @BotFather.
+	BotToken string
+	// Id of chat, group chat ids are negative.
+	ChatID string
+	// Optional, client with 10 seconds timeout is used by default.
+	Client *http.Client
+}
+
+func (t Telegram) Notify(e log.Event) error {
+	u := "https://api.telegram.org/bot" + t.BotToken + "/sendMessage"
+	err := postJSON(t.Client, u, map[string]string{
+		"chat_id": t.ChatID,
+		"text":    Summary(e),
+	})
+	// Error of client contains URL with bot token, it must not be logged.
+	if urlErr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s api.telegram.org: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package notifier
+
+import (
+	"context"
+	"lib/log"
+	"sync"
+	"time"
+)
+
+// Throttle is the cache of messages and errors mentioned in log package:
+// it wraps notifier to prevent notification spam.
+//   - identical events (same message and error) are notified once per Window,
+//   - no more than PerMinute notifications are sent per minute.
+// Suppressed events are not lost silently: their counts are sent as one summary notification
+// a minute after the first suppression.
+// Use it by pointer: &notifier.Throttle{Notifier: ...}.
+type Throttle struct {
+	Notifier Notifier
+	// 10 minutes by default.
+	Window time.Duration
+	// 10 by default.
+	PerMinute int
+
+	mu         sync.Mutex
+	seen       map[throttleKey]time.Time
+	minute     time.Time
+	sent       int
+	suppressed map[throttleKey]int
+}
+
+type throttleKey struct {
+	message string
+	error   string
+}
+
+func (t *Throttle) Notify(e log.Event) error {
+	if !t.allow(e) {
+		return nil
+	}
+	return t.Notifier.Notify(e)
+}
+
+func (t *Throttle) allow(e log.Event) bool {
+	window := t.Window
+	if window == 0 {
+		window = 10 * time.Minute
+	}
+	perMinute := t.PerMinute
+	if perMinute == 0 {
+		perMinute = 10
+	}
+	now := time.Now()
+	k := throttleKey{e.Message, e.Error}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		t.seen = make(map[throttleKey]time.Time)
+	}
+	for key, at := range t.seen {
+		if now.Sub(at) > window {
+			delete(t.seen, key)
+		}
+	}
+	if now.Sub(t.minute) > time.Minute {
+		t.minute = now
+		t.sent = 0
+	}
+
+	if _, ok := t.seen[k]; ok || t.sent >= perMinute {
+		if t.suppressed == nil {
+			t.suppressed = make(map[throttleKey]int)
+			time.AfterFunc(time.Minute, t.summarize)
+		}
+		t.suppressed[k]++
+		return false
+	}
+	t.seen[k] = now
+	t.sent++
+	return true
+}
+
+// Flush sends summary of suppressed events immediately and flushes wrapped notifier.
+func (t *Throttle) Flush(ctx context.Context) error {
+	t.summarize()
+	if f, ok := t.Notifier.(log.Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
+// Summary bypasses limits: otherwise suppressed events would be lost silently.
+func (t *Throttle) summarize() {
+	t.mu.Lock()
+	suppressed := t.suppressed
+	t.suppressed = nil
+	t.mu.Unlock()
+	if len(suppressed) == 0 {
+		return
+	}
+
+	e := log.Event{
+		SchemaVersion: log.SchemaVersion,
+		Message:       "suppressed notifications",
+		Error:         "notification spam",
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		Hostname:      log.HOSTNAME,
+		Service:       log.Service,
+		Context:       make(map[string]interface{}),
+	}
+	for k, n := range suppressed {
+		name := k.message
+		if k.error != "" {
+			name += ": " + k.error
+		}
+		e.Context[name] = n
+	}
+	if err := t.Notifier.Notify(e); err != nil {
+		logFailure(t, err, e)
+	}
+}
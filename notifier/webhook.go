@@ -0,0 +1,104 @@
+package notifier
+
+import (
+	"context"
+	"lib/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhook POSTs event as JSON (the same fields as in log) to arbitrary URL,
+// e.g. PagerDuty or Opsgenie integration endpoint.
+// Events are queued and delivered one by one in background. Failed delivery is retried
+// with exponential backoff, so downtime of the endpoint doesn't lose alerts.
+// Queue is bounded: when it's full the oldest event is dropped and the drop is logged.
+// Use it by pointer: &notifier.Webhook{...}.
+type Webhook struct {
+	URL string
+	// Optional, client with 10 seconds timeout is used by default.
+	Client *http.Client
+	// Max events waiting for delivery, 100 by default.
+	BufferSize int
+	// Max delivery attempts of event, 10 by default.
+	MaxAttempts int
+	// Delay before the first retry, it's doubled for each next one up to MaxBackoff.
+	// 1 second and 1 minute by default.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	once    sync.Once
+	queue   chan log.Event
+	pending pending
+}
+
+func (w *Webhook) Notify(e log.Event) error {
+	w.once.Do(w.start)
+	w.pending.add(1)
+	for {
+		select {
+		case w.queue <- e:
+			return nil
+		default:
+		}
+		select {
+		case dropped := <-w.queue:
+			w.pending.add(-1)
+			log.Log(
+				"dropped notification",
+				log.ReferenceID(dropped.ReferenceID),
+				log.Context(map[string]string{"reason": "webhook queue is full", "url": w.URL, "message": dropped.Message}),
+			)
+		default:
+		}
+	}
+}
+
+func (w *Webhook) start() {
+	size := w.BufferSize
+	if size == 0 {
+		size = 100
+	}
+	w.queue = make(chan log.Event, size)
+	go func() {
+		for e := range w.queue {
+			w.deliver(e)
+			w.pending.add(-1)
+		}
+	}()
+}
+
+// Flush waits until queued events are delivered.
+func (w *Webhook) Flush(ctx context.Context) error {
+	return w.pending.wait(ctx)
+}
+
+func (w *Webhook) deliver(e log.Event) {
+	attempts := w.MaxAttempts
+	if attempts == 0 {
+		attempts = 10
+	}
+	backoff := w.MinBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	maxBackoff := w.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = time.Minute
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = postJSON(w.Client, w.URL, &e); err == nil {
+			return
+		}
+		if i == attempts-1 {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	logFailure(w, err, e)
+}
@@ -0,0 +1,97 @@
+package problem
+
+import (
+	"context"
+	"errors"
+	"lib/log"
+	"net/http"
+)
+
+// Sentinel errors of client mistakes, wrap them to add detail for client:
+//   return fmt.Errorf("%w: name is required", problem.ErrBadRequest)
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+)
+
+// ErrorStatus is status code of response to error.
+type ErrorStatus struct {
+	Err    error
+	Status int
+}
+
+// Statuses maps errors to status codes of response, other errors are internal (500).
+// They are matched in order, so error wrapping several targets (errors.Join, several %w)
+// always gets the same status: of the first one. Customize Statuses for project in init function:
+//   problem.Statuses = append(problem.Statuses, problem.ErrorStatus{Err: sql.ErrNoRows, Status: http.StatusNotFound})
+var Statuses = []ErrorStatus{
+	{ErrBadRequest, http.StatusBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrForbidden, http.StatusForbidden},
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+}
+
+// Status returns status code of response to error, see Statuses.
+// Errors are matched by errors.Is, so wrapped errors are mapped too.
+func Status(err error) int {
+	// Body of request is over limit of http.MaxBytesReader.
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	for _, s := range Statuses {
+		if errors.Is(err, s.Err) {
+			return s.Status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// HandlerFunc is handler which returns error instead of writing error response itself:
+//   router.Handler("POST", "/person/:name", problem.HandlerFunc(post))
+// Returned error is logged with reference id and written as problem with status of error (see Status).
+// Handler must not write response if it returns error.
+// Error of handler whose client has disconnected is not logged as Error and no response is written:
+// it's a consequence of canceled context of request (see StatusClientClosedRequest).
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+func (h HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := h(w, r)
+	if err == nil {
+		return
+	}
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		log.Log(
+			"client disconnected",
+			log.FromContext(r.Context()),
+			log.Context(map[string]string{"reason": err.Error()}),
+			log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		)
+		return
+	}
+	status := Status(err)
+	if status >= 500 {
+		// Details of internal error are only in log.
+		log.Log(
+			"failed handler",
+			log.FromContext(r.Context()),
+			log.Error(err),
+			log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+		)
+		Write(w, r, status)
+		return
+	}
+	// Client mistake is not logged as Error, people on duty must not be notified about it.
+	log.Log(
+		"rejected request",
+		log.FromContext(r.Context()),
+		log.Context(map[string]string{"reason": err.Error()}),
+		log.Int("status_code", status),
+		log.Request(r.Method, r.Host, r.URL.Path, r.URL.Query(), nil, nil),
+	)
+	New(status).WithDetail(err.Error()).Write(w, r)
+}
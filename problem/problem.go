@@ -0,0 +1,80 @@
+// Package problem writes error responses in format of RFC 7807 (application/problem+json):
+//   {"type": "about:blank", "title": "Not Found", "status": 404, "reference_id": "..."}
+// Reference id of request is included automatically: client reports it and details
+// of error are found in log by it. Details of internal errors must not be sent to client.
+package problem
+
+import (
+	"encoding/json"
+	"lib/log"
+	"net/http"
+)
+
+const ContentType = "application/problem+json"
+
+// StatusClientClosedRequest is non-standard status (introduced by nginx) of request whose client
+// closed connection before response was sent. It's never sent, only logged.
+const StatusClientClosedRequest = 499
+
+// Problem is body of error response, see RFC 7807.
+type Problem struct {
+	// URI of problem type documentation, "about:blank" means status code is enough.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail is explanation for client, e.g. what is wrong with request.
+	Detail string `json:"detail,omitempty"`
+	// URI of occurrence of problem, e.g. path of request.
+	Instance    string `json:"instance,omitempty"`
+	ReferenceID string `json:"reference_id,omitempty"`
+	// Errors of fields of invalid request, see WithErrors.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes invalid field of request body, Field is path of field: "address.city", "items[0].id".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New returns problem of status with standard title.
+func New(status int) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status}
+}
+
+// WithDetail returns copy of problem with detail for client.
+func (p *Problem) WithDetail(detail string) *Problem {
+	c := *p
+	c.Detail = detail
+	return &c
+}
+
+// WithErrors returns copy of problem with errors of fields.
+func (p *Problem) WithErrors(errs []FieldError) *Problem {
+	c := *p
+	c.Errors = errs
+	return &c
+}
+
+// Write writes problem as response to request with its reference id.
+// Headers set before (e.g. Retry-After, WWW-Authenticate) are kept.
+func (p *Problem) Write(w http.ResponseWriter, r *http.Request) {
+	c := *p
+	if c.ReferenceID == "" {
+		c.ReferenceID = log.ReferenceIDFromContext(r.Context())
+	}
+	body, err := json.Marshal(&c)
+	if err != nil {
+		// Problem consists of strings and ints, it can't fail.
+		panic(err)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(c.Status)
+	w.Write(body)
+}
+
+// Write writes problem of status with standard title.
+func Write(w http.ResponseWriter, r *http.Request, status int) {
+	New(status).Write(w, r)
+}
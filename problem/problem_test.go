@@ -0,0 +1,54 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lib/log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(log.WithReferenceID(r.Context(), "abc"))
+	w := httptest.NewRecorder()
+	New(404).WithDetail("no such article").Write(w, r)
+
+	if w.Code != 404 || w.Header().Get("Content-Type") != ContentType {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+	want := `{"type":"about:blank","title":"Not Found","status":404,"detail":"no such article","reference_id":"abc"}`
+	if w.Body.String() != want {
+		t.Errorf("got %s, want %s", w.Body, want)
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	defer func(w io.Writer) { log.Writer = w }(log.Writer)
+	log.Writer = io.Discard
+
+	for _, tc := range []struct {
+		err    error
+		status int
+		detail string
+	}{
+		{fmt.Errorf("%w: name is required", ErrBadRequest), 400, "bad request: name is required"},
+		{fmt.Errorf("get article: %w", ErrNotFound), 404, "get article: not found"},
+		{errors.New("connection refused"), 500, ""},
+		// The first matching target of Statuses wins.
+		{errors.Join(ErrNotFound, ErrBadRequest), 400, "not found\nbad request"},
+	} {
+		w := httptest.NewRecorder()
+		HandlerFunc(func(w http.ResponseWriter, r *http.Request) error { return tc.err }).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		var p Problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tc.status || p.Status != tc.status || p.Detail != tc.detail {
+			t.Errorf("%v: unexpected response %d %s", tc.err, w.Code, w.Body)
+		}
+	}
+}
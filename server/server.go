@@ -0,0 +1,96 @@
+// Package server runs HTTP server with timeouts and graceful shutdown:
+//   if err := server.ListenAndServe(":8080", router); err != nil {
+//       os.Exit(1)
+//   }
+// On SIGINT/SIGTERM server stops accepting connections, waits for in-flight requests
+// for ShutdownTimeout at most, then flushes queued events of middleware and log.
+package server
+
+import (
+	"context"
+	"errors"
+	"lib/log"
+	"lib/middleware"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Without timeouts slow or stale clients hold connections and goroutines forever.
+// WriteTimeout limits handling of request too, increase it for slow handlers and streaming.
+// Customize timeouts for project in init function:
+//   server.WriteTimeout = 5 * time.Minute
+var (
+	ReadHeaderTimeout = 5 * time.Second
+	ReadTimeout       = 30 * time.Second
+	WriteTimeout      = 60 * time.Second
+	IdleTimeout       = 120 * time.Second
+
+	// ShutdownTimeout is grace period for in-flight requests on shutdown.
+	// Keep it below termination grace period of orchestrator (30s in Kubernetes).
+	ShutdownTimeout = 25 * time.Second
+)
+
+// New returns http.Server with timeouts, its errors (e.g. TLS handshake errors) are logged as events.
+func New(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		IdleTimeout:       IdleTimeout,
+		ErrorLog:          log.StdLogger("http.Server"),
+	}
+}
+
+// ListenAndServe serves handler on addr until SIGINT or SIGTERM and shuts down gracefully.
+// Error is returned if server failed to start or shutdown didn't finish in time.
+func ListenAndServe(addr string, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return Serve(ctx, New(addr, handler))
+}
+
+// Serve runs server until ctx is done and shuts it down gracefully.
+func Serve(ctx context.Context, server *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	log.Log("started server", log.Context(map[string]string{"addr": server.Addr}))
+
+	select {
+	case err := <-errs:
+		log.Log("failed server.ListenAndServe", log.Error(err), log.Context(map[string]string{"addr": server.Addr}))
+		closeLog()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Log("shutting down server", log.Context(map[string]string{"addr": server.Addr}))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Log("failed server.Shutdown", log.Error(err), log.Context(map[string]string{"addr": server.Addr}))
+	} else {
+		log.Log("stopped server", log.Context(map[string]string{"addr": server.Addr}))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Remaining requests are cut off, flushing is still worth a try.
+		server.Close()
+	}
+	closeLog()
+	return err
+}
+
+// Queued transaction events are flushed first, they are written through log.
+func closeLog() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	middleware.Flush(ctx)
+	log.Close(ctx)
+}